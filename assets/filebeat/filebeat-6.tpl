@@ -2,7 +2,7 @@
 - type: log
   enabled: true
   paths:
-      - {{ .LogFile }}
+      - {{ quote .LogFile }}
   scan_frequency: 10s
   fields_under_root: true
   {{if .Stdout}}
@@ -14,7 +14,7 @@
   fields:
       cluster: ${CLUSTER_ID}
       {{- range $key, $value := .Tags }}
-      {{ $key }}: "{{ $value }}"
+      {{ quote $key }}: {{ quote $value }}
       {{- end }}
   tail_files: false
   # Harvester closing options
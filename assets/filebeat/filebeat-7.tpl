@@ -0,0 +1,35 @@
+{{- /* Requires filebeat 7.16 or later, which supports the filestream input
+  with the container parser. */ -}}
+{{range .configList}}
+- type: filestream
+  id: {{ quote (printf "%s-%s" $.containerId .Name) }}
+  enabled: true
+  paths:
+      - {{ quote .LogFile }}
+  prospector.scanner.check_interval: 10s
+  fields_under_root: true
+  {{- if .Stdout }}
+  parsers:
+    - container:
+        stream: all
+        format: docker
+  {{- else if eq .Format "json" }}
+  parsers:
+    - ndjson:
+        target: ""
+        overwrite_keys: true
+  {{- end }}
+  fields:
+      cluster: ${CLUSTER_ID}
+      {{- range $key, $value := .Tags }}
+      {{ quote $key }}: {{ quote $value }}
+      {{- end }}
+  # Harvester closing options
+  close.on_state_change.inactive: 5m
+  close.on_state_change.removed: false
+  close.on_state_change.renamed: false
+  ignore_older: 48h
+  # State options
+  clean_removed: true
+  clean_inactive: 72h
+{{- end}}
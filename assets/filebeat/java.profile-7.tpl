@@ -0,0 +1,34 @@
+{{- /* Java logs, lines of stack traces are joined to the previous line. */ -}}
+{{range .configList}}
+- type: filestream
+  id: {{ quote (printf "%s-%s" $.containerId .Name) }}
+  enabled: true
+  paths:
+      - {{ quote .LogFile }}
+  prospector.scanner.check_interval: 10s
+  fields_under_root: true
+  parsers:
+    {{- if .Stdout }}
+    - container:
+        stream: all
+        format: docker
+    {{- end }}
+    - multiline:
+        type: pattern
+        pattern: '^[[:space:]]+(at|\.{3})[[:space:]]+\b|^Caused by:'
+        negate: false
+        match: after
+  fields:
+      cluster: ${CLUSTER_ID}
+      {{- range $key, $value := .Tags }}
+      {{ quote $key }}: {{ quote $value }}
+      {{- end }}
+  # Harvester closing options
+  close.on_state_change.inactive: 5m
+  close.on_state_change.removed: false
+  close.on_state_change.renamed: false
+  ignore_older: 48h
+  # State options
+  clean_removed: true
+  clean_inactive: 72h
+{{- end}}
@@ -0,0 +1,33 @@
+{{- /* Java logs, lines of stack traces are joined to the previous line. */ -}}
+{{range .configList}}
+- type: log
+  enabled: true
+  paths:
+      - {{ quote .LogFile }}
+  scan_frequency: 10s
+  fields_under_root: true
+  {{- if .Stdout }}
+  docker-json:
+    stream: all
+    partial: true
+    cri_flags: true
+  {{- end }}
+  multiline.pattern: '^[[:space:]]+(at|\.{3})[[:space:]]+\b|^Caused by:'
+  multiline.negate: false
+  multiline.match: after
+  fields:
+      cluster: ${CLUSTER_ID}
+      {{- range $key, $value := .Tags }}
+      {{ quote $key }}: {{ quote $value }}
+      {{- end }}
+  tail_files: false
+  # Harvester closing options
+  close_eof: false
+  close_inactive: 5m
+  close_removed: false
+  close_renamed: false
+  ignore_older: 48h
+  # State options
+  clean_removed: true
+  clean_inactive: 72h
+{{- end}}
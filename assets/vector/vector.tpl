@@ -0,0 +1,64 @@
+{{- /*
+  Vector configuration for a single container. Vector should load the
+  directory with --config-dir and --watch-config, and sinks should consume
+  events with the input wildcard "logpilot_out_*".
+*/ -}}
+{{- $cid := .containerId -}}
+sources:
+{{- range .configList }}
+  {{ componentID "src" $cid .Name }}:
+    type: file
+    include:
+      - {{ quote .LogFile }}
+    read_from: beginning
+    {{- with index .InOpts "multiline_pattern" }}
+    multiline:
+      start_pattern: {{ quote . }}
+      condition_pattern: {{ quote . }}
+      mode: halt_before
+      timeout_ms: 1000
+    {{- end }}
+{{- end }}
+transforms:
+{{- range .configList }}
+  {{- $cfg := . }}
+  {{- $name := .Name }}
+  {{- with index .InOpts "include_lines" }}
+  {{ componentID "include" $cid $name }}:
+    type: filter
+    inputs:
+      - {{ componentID "src" $cid $name }}
+    condition:
+      type: vrl
+      source: {{ quote (printf "match(string!(.message), %s)" (vrlRegex .)) }}
+  {{- end }}
+  {{- with index .InOpts "exclude_lines" }}
+  {{ componentID "exclude" $cid $name }}:
+    type: filter
+    inputs:
+      - {{ upstream "exclude" $cid $cfg }}
+    condition:
+      type: vrl
+      source: {{ quote (printf "!match(string!(.message), %s)" (vrlRegex .)) }}
+  {{- end }}
+  {{ componentID "out" $cid .Name }}:
+    type: remap
+    inputs:
+      - {{ upstream "out" $cid . }}
+    source: |
+      {{- if .Stdout }}
+      docker = object!(parse_json!(string!(.message)))
+      .message = replace(string!(docker.log), r'\n$', "")
+      .stream = docker.stream
+      .timestamp = parse_timestamp(string!(docker.time), "%+") ?? now()
+      {{- end }}
+      {{- if eq .Format "json" }}
+      parsed, err = parse_json(string!(.message))
+      if err == null && is_object(parsed) {
+        . = merge(., object!(parsed))
+      }
+      {{- end }}
+      {{- range $key, $value := .Tags }}
+      {{ vrlPath $key }} = {{ vrlString $value }}
+      {{- end }}
+{{- end }}
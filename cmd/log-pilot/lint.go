@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/configurer/filebeat"
+	"github.com/caicloud/log-pilot/pilot/discovery"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const lintUsage = `Usage:
+  log-pilot lint [flags] <manifest.yaml>...
+
+Check logging env and annotations of pods in manifests, which may contain
+multiple documents of Pod, Deployment, StatefulSet, DaemonSet, ReplicaSet, Job
+and CronJob. Other kinds are ignored. Use "-" to read from stdin. Profiles are
+checked if path.template is set. It exits with 1 if any problem is found.
+
+Flags:
+`
+
+// workload is a pod or an object with pod template, e.g. Deployment.
+type workload struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Template    *corev1.PodTemplateSpec `json:"template,omitempty"`
+		JobTemplate *struct {
+			Spec struct {
+				Template *corev1.PodTemplateSpec `json:"template,omitempty"`
+			} `json:"spec"`
+		} `json:"jobTemplate,omitempty"`
+	} `json:"spec"`
+}
+
+var docSeparator = regexp.MustCompile(`(?m)^---.*$`)
+
+// runLint implements the lint subcommand.
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, lintUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	log.Config("critical", "", true, false, *logMaxBytes, *logMaxBackups)
+
+	opts := &discovery.LintOptions{
+		LogPrefix: *logPrefix,
+		BListNS:   parseList(*bListNS),
+		WListNS:   parseList(*wListNS),
+	}
+	if *template != "" {
+		profiles, err := filebeat.Profiles(*template, *fbVersion)
+		if err != nil {
+			return err
+		}
+		opts.Profiles = profiles
+	}
+
+	found := 0
+	for _, file := range fs.Args() {
+		pods, err := readPods(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, p := range pods {
+			for _, problem := range discovery.LintPod(opts, p.pod) {
+				fmt.Printf("%s: %s: %s\n", file, p.ref, problem)
+				found++
+			}
+		}
+	}
+	if found > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", found)
+		os.Exit(1)
+	}
+	return nil
+}
+
+// manifestPod is a pod, or pod template of the workload, in manifest.
+type manifestPod struct {
+	// ref is <kind> <namespace>/<name> of the object.
+	ref string
+	pod *corev1.Pod
+}
+
+// readPods reads pods and pod templates in the manifest file.
+func readPods(file string) ([]manifestPod, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []manifestPod{}
+	for i, doc := range docSeparator.Split(string(data), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		w := &workload{}
+		if err := yaml.Unmarshal([]byte(doc), w); err != nil {
+			return nil, fmt.Errorf("error decode document %d: %v", i, err)
+		}
+		namespace := w.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		ref := fmt.Sprintf("%s %s/%s", w.Kind, namespace, w.Name)
+
+		var pod *corev1.Pod
+		switch w.Kind {
+		case "Pod":
+			pod = &corev1.Pod{}
+			if err := yaml.Unmarshal([]byte(doc), pod); err != nil {
+				return nil, fmt.Errorf("error decode %s: %v", ref, err)
+			}
+		case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+			pod = podOfTemplate(w.Spec.Template)
+		case "CronJob":
+			if w.Spec.JobTemplate != nil {
+				pod = podOfTemplate(w.Spec.JobTemplate.Spec.Template)
+			}
+		default:
+			continue
+		}
+		if pod == nil {
+			return nil, fmt.Errorf("%s has no pod template", ref)
+		}
+		pod.Name = w.Name
+		pod.Namespace = namespace
+		ret = append(ret, manifestPod{ref: ref, pod: pod})
+	}
+	return ret, nil
+}
+
+func podOfTemplate(t *corev1.PodTemplateSpec) *corev1.Pod {
+	if t == nil {
+		return nil
+	}
+	return &corev1.Pod{
+		ObjectMeta: t.ObjectMeta,
+		Spec:       t.Spec,
+	}
+}
@@ -2,52 +2,118 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"text/tabwriter"
 	"time"
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/configurer/composite"
 	"github.com/caicloud/log-pilot/pilot/configurer/filebeat"
+	"github.com/caicloud/log-pilot/pilot/configurer/otel"
+	"github.com/caicloud/log-pilot/pilot/configurer/vector"
 	"github.com/caicloud/log-pilot/pilot/discovery"
+	"github.com/caicloud/log-pilot/pilot/health"
+	"github.com/caicloud/log-pilot/pilot/kube"
 	"github.com/caicloud/log-pilot/pilot/log"
 	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
-	template      = flag.String("path.template", "", "Template file path for filebeat")
-	filebeatHome  = flag.String("path.filebeat-home", "", "Filebeat home path")
-	base          = flag.String("path.base", "/", "Directory which mount host path")
-	logPath       = flag.String("path.logs", "", "Logs path")
-	logPrefix     = flag.String("logPrefix", "caicloud", "Log prefix of the env parameters. Multiple prefixes should be separated by \",\"")
-	logLevel      = flag.String("logLevel", "info", "Log level: debug, info, warning, error, critical")
-	wListNS       = flag.String("namespace.whitelist", "", "whitelist of namespaces to watch")
-	bListNS       = flag.String("namespace.blacklist", "", "blacklist of namespaces to ignore")
-	logMaxBytes   = flag.Uint("log.maxSize", 10*1024*1024, "Max size of log file in bytes")
-	logMaxBackups = flag.Uint("log.maxBackups", 7, "Max backups of log files")
-	logToStderr   = flag.Bool("e", false, "Log to stderr")
+	backend        = flag.String("configurer", "filebeat", "Configurer backends: filebeat, vector, otel. Multiple backends should be separated by \",\"")
+	template       = flag.String("path.template", "", "Template file path for the configurer, or template sets directory for filebeat")
+	filebeatHome   = flag.String("path.filebeat-home", "", "Filebeat home path")
+	gcInterval     = flag.Duration("gc.interval", configurer.DefaultGCPolicy().ScanInterval, "Interval to check input files of destroyed containers")
+	gcMinLinger    = flag.Duration("gc.min-linger", configurer.DefaultGCPolicy().MinLinger, "Minimum time to keep input files after containers destroyed")
+	gcMaxDrain     = flag.Duration("gc.max-drain", configurer.DefaultGCPolicy().MaxDrain, "Maximum time to wait for logs to be shipped after containers destroyed, input files are removed by force then")
+	fbVersion      = flag.String("filebeat.version", filebeat.DefaultFilebeatVersion, "Version of filebeat, which decides the template set to use if path.template is a directory")
+	fbLagInterval  = flag.Duration("filebeat.lag-interval", filebeat.DefaultLagInterval, "Interval to update collection lag of filebeat, which reads the registry and stats all the log files")
+	vectorTemplate = flag.String("path.vector-template", "", "Template file path for vector, defaults to path.template")
+	vectorConfig   = flag.String("path.vector-config", "", "Directory loaded by vector with --config-dir")
+	vectorData     = flag.String("path.vector-data", "", "Data directory of vector, where checkpoints are stored")
+	otelTemplate   = flag.String("path.otel-template", "", "Template file path for the OpenTelemetry Collector, defaults to path.template")
+	otelConfig     = flag.String("path.otel-config", "", "Directory where receiver configs for the OpenTelemetry Collector are written")
+	otelStorage    = flag.String("otel.storage", "", "Name of the file_storage extension used by filelog receivers")
+	otelStorageDir = flag.String("path.otel-storage", "", "Directory of the file_storage extension")
+	otelReload     = flag.String("otel.reload-process", "", "Name of the OpenTelemetry Collector process, which is sent SIGHUP to reload the merged config after it changes and must share the process namespace. Leave it empty only if the collector watches the config file")
+	base           = flag.String("path.base", "/", "Directory which mount host path")
+	logPath        = flag.String("path.logs", "", "Logs path")
+	logPrefix      = flag.String("logPrefix", "caicloud", "Log prefix of the env parameters. Multiple prefixes should be separated by \",\"")
+	logLevel       = flag.String("logLevel", "info", "Log level: debug, info, warning, error, critical")
+	wListNS        = flag.String("namespace.whitelist", "", "whitelist of namespaces to watch")
+	bListNS        = flag.String("namespace.blacklist", "", "blacklist of namespaces to ignore")
+	logMaxBytes    = flag.Uint("log.maxSize", 10*1024*1024, "Max size of log file in bytes")
+	logMaxBackups  = flag.Uint("log.maxBackups", 7, "Max backups of log files")
+	logToStderr    = flag.Bool("e", false, "Log to stderr")
+	logJSON        = flag.Bool("log.json", false, "Write logs as JSON objects, with identity of containers as fields")
+	httpListen     = flag.String("http.listen", ":9080", "Address to serve metrics at /metrics, health checks at /healthz and /readyz, empty to disable")
+	debugListen    = flag.String("debug.listen", "", "Address to serve tracked containers at /debug/containers, e.g. localhost:9081. It has no authentication and is disabled if empty")
+	podStatus      = flag.Bool("pod.status", false, "Write collection status of pods to annotation "+kube.AnnotationStatus+", which requires permission to patch pods")
+	migrateDryRun  = flag.Bool("migrate.dry-run", false, "Report how input files of old versions would be migrated and exit")
 )
 
+// subcommands run instead of log-pilot if given as the first argument.
+var subcommands = map[string]func(args []string) error{
+	"render":  runRender,
+	"lint":    runLint,
+	"webhook": runWebhook,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
-	log.Config(*logLevel, *logPath, *logToStderr, *logMaxBytes, *logMaxBackups)
+	log.Config(*logLevel, *logPath, *logToStderr, *logJSON, *logMaxBytes, *logMaxBackups)
 
 	baseDir, err := filepath.Abs(*base)
 	if err != nil {
 		log.Fatal("Invalid path.base:", err)
 	}
-	var cfgr configurer.Configurer
-	cfgr, err = filebeat.New(baseDir, *template, *filebeatHome)
+	// Backends create their directories, which a dry run must not do.
+	if *migrateDryRun {
+		if err := reportMigrations(parseList(*backend)); err != nil {
+			log.Fatalf("Error migrate: %v", err)
+		}
+		return
+	}
+
+	cfgr, err := newBackends(parseList(*backend), baseDir)
 	if err != nil {
 		log.Fatalf("Error create configurer: %v", err)
 	}
 
-	d, err := discovery.New(baseDir, *logPrefix, cfgr, parseList(*bListNS), parseList(*wListNS))
+	d, err := discovery.New(baseDir, *logPrefix, cfgr, parseList(*bListNS), parseList(*wListNS), *podStatus)
 	if err != nil {
 		log.Fatalf("Error create discovery: %v", err)
 	}
 
+	if *httpListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.Handle("/healthz", health.Handler(d.Healthz()...))
+		mux.Handle("/readyz", health.Handler(d.Readyz()...))
+		go serveHTTP(*httpListen, mux)
+	}
+	if *debugListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/containers", configurer.InspectHandler(cfgr))
+		go serveHTTP(*debugListen, mux)
+	}
+
 	go func() {
 		if err := d.Start(); err != nil {
 			log.Fatalf("Error start discovery: %v", err)
@@ -65,6 +131,107 @@ func main() {
 	os.Exit(0)
 }
 
+// serveHTTP serves the handlers on addr, log-pilot keeps running if it fails.
+func serveHTTP(addr string, mux *http.ServeMux) {
+	log.Infof("Serving http on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Errorf("Error serve http on %s: %v", addr, err)
+	}
+}
+
+// Namespace filters of each backend, which are used when multiple backends
+// are configured.
+var (
+	backendBListNS = map[string]*string{}
+	backendWListNS = map[string]*string{}
+)
+
+func init() {
+	for _, name := range []string{"filebeat", "vector", "otel"} {
+		backendBListNS[name] = flag.String(name+".namespace.blacklist", "", "blacklist of namespaces ignored by "+name)
+		backendWListNS[name] = flag.String(name+".namespace.whitelist", "", "whitelist of namespaces handled by "+name)
+	}
+}
+
+func newBackends(names []string, baseDir string) (configurer.Configurer, error) {
+	if len(names) == 1 {
+		return newConfigurer(names[0], baseDir)
+	}
+
+	backends := []composite.Backend{}
+	for _, name := range names {
+		c, err := newConfigurer(name, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("error create %s: %v", name, err)
+		}
+		backends = append(backends, composite.Backend{
+			Configurer: c,
+			BListNS:    parseList(*backendBListNS[name]),
+			WListNS:    parseList(*backendWListNS[name]),
+		})
+	}
+	return composite.New(backends...)
+}
+
+func newConfigurer(name, baseDir string) (configurer.Configurer, error) {
+	gcPolicy := configurer.GCPolicy{
+		ScanInterval: *gcInterval,
+		MinLinger:    *gcMinLinger,
+		MaxDrain:     *gcMaxDrain,
+	}
+	if err := gcPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	switch name {
+	case "filebeat":
+		return filebeat.New(baseDir, *template, *filebeatHome, *fbVersion, gcPolicy, *fbLagInterval)
+	case "vector":
+		return vector.New(baseDir, orDefault(*vectorTemplate, *template), *vectorConfig, *vectorData, gcPolicy)
+	case "otel":
+		return otel.New(baseDir, orDefault(*otelTemplate, *template), *otelConfig, *otelStorage, *otelStorageDir, *otelReload, gcPolicy)
+	}
+	return nil, fmt.Errorf("unknown configurer %q", name)
+}
+
+// reportMigrations prints what would be done to input files of old versions
+// by the backends, only filebeat supports migration.
+func reportMigrations(names []string) error {
+	migrations := []*configurer.Migration{}
+	supported := false
+	for _, name := range names {
+		if name != "filebeat" {
+			continue
+		}
+		supported = true
+		m, err := filebeat.NewMigrator(*template, *filebeatHome, *fbVersion)
+		if err != nil {
+			return fmt.Errorf("error create %s: %v", name, err)
+		}
+		ret, err := m.Migrate(true)
+		if err != nil {
+			return err
+		}
+		migrations = append(migrations, ret...)
+	}
+	if !supported {
+		return fmt.Errorf("%s does not support migration", strings.Join(names, ","))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tCONTAINER\tFROM\tTO\tACTION\tERROR")
+	for _, m := range migrations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", m.Path, m.ContainerID, m.From, m.To, m.Action, m.Error)
+	}
+	return w.Flush()
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
 func parseList(raw string) []string {
 	if raw == "" {
 		return nil
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer/filebeat"
+	"github.com/caicloud/log-pilot/pilot/discovery"
+	"github.com/caicloud/log-pilot/pilot/kube"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mountFlags are simulated mounts in format of <container path>=<host path>.
+type mountFlags map[string]string
+
+func (m mountFlags) String() string {
+	items := []string{}
+	for dest, source := range m {
+		items = append(items, dest+"="+source)
+	}
+	return strings.Join(items, ",")
+}
+
+func (m mountFlags) Set(v string) error {
+	items := strings.SplitN(v, "=", 2)
+	if len(items) != 2 || !filepath.IsAbs(items[0]) || !filepath.IsAbs(items[1]) {
+		return fmt.Errorf("expect <container path>=<host path>, got %q", v)
+	}
+	m[items[0]] = items[1]
+	return nil
+}
+
+const renderUsage = `Usage:
+  log-pilot render [flags] <container id>
+  log-pilot render [flags] --pod=<pod.yaml> [--container=<name>] [--mount=<container path>=<host path>]...
+
+Render the filebeat input config of a running container, or a container in a
+pod manifest, and print it with warnings found while parsing log configs.
+Nothing is written to inputs.d.
+
+Flags:
+`
+
+// runRender implements the render subcommand.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	podFile := fs.String("pod", "", "Pod manifest to render, instead of a running container")
+	containerName := fs.String("container", "", "Container name in the pod manifest, required if the pod has multiple containers")
+	mounts := mountFlags{}
+	fs.Var(mounts, "mount", "Simulated mount <container path>=<host path> of the container in pod manifest, can be repeated")
+	// Flags of log-pilot are shared, e.g. path.template and logPrefix.
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, renderUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// Only errors are logged by default, which are not reported as warnings.
+	level := "error"
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "logLevel" {
+			level = *logLevel
+		}
+	})
+	log.Config(level, "", true, false, *logMaxBytes, *logMaxBackups)
+
+	baseDir, err := filepath.Abs(*base)
+	if err != nil {
+		return fmt.Errorf("invalid path.base: %v", err)
+	}
+	opts := &discovery.InspectOptions{
+		BaseDir:   baseDir,
+		LogPrefix: *logPrefix,
+		BListNS:   parseList(*bListNS),
+		WListNS:   parseList(*wListNS),
+	}
+
+	var (
+		containerJSON *types.ContainerJSON
+		warnings      []string
+	)
+	switch {
+	case *podFile != "":
+		data, err := ioutil.ReadFile(*podFile)
+		if err != nil {
+			return err
+		}
+		pod := &corev1.Pod{}
+		if err := yaml.Unmarshal(data, pod); err != nil {
+			return fmt.Errorf("error decode pod: %v", err)
+		}
+		opts.Cache = kube.NewStaticCache(pod)
+		containerJSON, warnings, err = discovery.SimulateContainer(pod, *containerName, "simulated", mounts)
+		if err != nil {
+			return err
+		}
+	case fs.NArg() == 1:
+		containerJSON, opts.Cache, warnings, err = inspectContainer(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ev, w, err := discovery.Inspect(opts, containerJSON)
+	warnings = append(warnings, w...)
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+	if err != nil {
+		return err
+	}
+	if ev == nil || len(ev.LogConfigs) == 0 {
+		return nil
+	}
+
+	r, err := filebeat.NewRenderer(*template, *fbVersion)
+	if err != nil {
+		return err
+	}
+	content, err := r.Render(ev)
+	fmt.Printf("# container %s (%s/%s/%s)\n", ev.Container.ID, ev.Container.Namespace, ev.Container.Pod, ev.Container.Name)
+	fmt.Println(content)
+	if err != nil {
+		return fmt.Errorf("invalid input config rendered: %v", err)
+	}
+	return nil
+}
+
+// inspectContainer inspects a running container, pod informations are read
+// from kubernetes if log-pilot runs in cluster.
+func inspectContainer(id string) (*types.ContainerJSON, kube.Cache, []string, error) {
+	if os.Getenv("DOCKER_API_VERSION") == "" {
+		os.Setenv("DOCKER_API_VERSION", "1.23")
+	}
+	dc, err := client.NewEnvClient()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error create docker client: %v", err)
+	}
+	defer dc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	containerJSON, err := dc.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	warnings := []string{}
+	cache, err := kube.New()
+	if err == nil {
+		err = cache.Start(ctx.Done())
+	}
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("pod annotations are ignored, error connect to kubernetes: %v", err))
+		cache = kube.NewStaticCache()
+	}
+	return &containerJSON, cache, warnings, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/caicloud/log-pilot/pilot/configurer/filebeat"
+	"github.com/caicloud/log-pilot/pilot/discovery"
+	"github.com/caicloud/log-pilot/pilot/log"
+	"github.com/caicloud/log-pilot/pilot/webhook"
+)
+
+const webhookUsage = `Usage:
+  log-pilot webhook [flags] --tls.cert=<cert file> --tls.key=<key file>
+
+Serve admission webhooks of pods on creation. Logging env and annotations are
+validated at /validate, pods with problems are allowed with warnings, or
+rejected if webhook.reject is set and some logs will never be collected. Pods
+in namespaces ignored by namespace.blacklist and namespace.whitelist are not
+validated. For pods annotated with logging.caicloud.io/inject-volumes: "true",
+an emptyDir volume is injected for each log directory not covered by volume
+mounts at /mutate. Directories less than 2 levels deep or parents of glob
+patterns are never injected, they are reported by validation.
+
+Flags:
+`
+
+// runWebhook implements the webhook subcommand.
+func runWebhook(args []string) error {
+	fs := flag.NewFlagSet("webhook", flag.ExitOnError)
+	listen := fs.String("webhook.listen", ":8443", "Address the webhook listens on")
+	reject := fs.Bool("webhook.reject", false, "Reject pods with logging problems instead of warning")
+	tlsCert := fs.String("tls.cert", "", "TLS certificate file of the webhook")
+	tlsKey := fs.String("tls.key", "", "TLS private key file of the webhook")
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, webhookUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *tlsCert == "" || *tlsKey == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	log.Config(*logLevel, *logPath, *logToStderr, *logJSON, *logMaxBytes, *logMaxBackups)
+
+	opts := webhook.Options{
+		Lint: discovery.LintOptions{
+			LogPrefix: *logPrefix,
+			BListNS:   parseList(*bListNS),
+			WListNS:   parseList(*wListNS),
+		},
+		Reject: *reject,
+	}
+	if *template != "" {
+		profiles, err := filebeat.Profiles(*template, *fbVersion)
+		if err != nil {
+			return err
+		}
+		opts.Lint.Profiles = profiles
+	}
+
+	log.Infof("Webhook listening on %s", *listen)
+	return http.ListenAndServeTLS(*listen, *tlsCert, *tlsKey, webhook.New(opts).Handler())
+}
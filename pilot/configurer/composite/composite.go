@@ -0,0 +1,402 @@
+package composite
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"go.uber.org/multierr"
+)
+
+// Backend is a configurer and the namespaces it is responsible for.
+type Backend struct {
+	Configurer configurer.Configurer
+	// Namespaces in BListNS are ignored, if WListNS is not empty, only
+	// namespaces in it are handled.
+	BListNS []string
+	WListNS []string
+}
+
+// retryInterval is the interval to retry backends which failed to start or
+// bootstrap, and containers which backends failed to add.
+const retryInterval = 30 * time.Second
+
+type backend struct {
+	configurer.Configurer
+	bListNS map[string]struct{}
+	wListNS map[string]struct{}
+	// started is false if the backend failed to start.
+	started bool
+	// ready is set once the backend is started and its input files are
+	// checked, it receives no events before that.
+	ready bool
+	// failed are containers the backend failed to add, they are retried
+	// until added or destroyed.
+	failed map[string]*configurer.ContainerAddEvent
+}
+
+func (b *backend) isResponsible(namespace string) bool {
+	if _, inBList := b.bListNS[namespace]; inBList {
+		return false
+	}
+	if len(b.wListNS) > 0 {
+		_, inWList := b.wListNS[namespace]
+		return inWList
+	}
+	return true
+}
+
+// compositeConfigurer forwards events to multiple configurers. Errors of a
+// backend are logged and do not prevent other backends from handling the
+// events, the failed backend is retried in background.
+type compositeConfigurer struct {
+	name     string
+	backends []*backend
+	logger   log.Logger
+	closeCh  chan struct{}
+
+	lock sync.Mutex
+	// bootstrapped is set once BootstrapCheck is done, backends becoming
+	// ready after that check their input files by containers.
+	bootstrapped bool
+	// containers are added and not destroyed yet.
+	containers map[string]*configurer.ContainerAddEvent
+}
+
+// New creates a configurer which fans out events to all the backends.
+func New(backends ...Backend) (configurer.Configurer, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backend configurer")
+	}
+
+	c := &compositeConfigurer{
+		logger:     log.NewLogger("configurer"),
+		closeCh:    make(chan struct{}),
+		containers: make(map[string]*configurer.ContainerAddEvent),
+	}
+	names := []string{}
+	for _, b := range backends {
+		names = append(names, b.Configurer.Name())
+		c.backends = append(c.backends, &backend{
+			Configurer: b.Configurer,
+			bListNS:    listToSet(b.BListNS),
+			wListNS:    listToSet(b.WListNS),
+			failed:     make(map[string]*configurer.ContainerAddEvent),
+		})
+	}
+	c.name = "composite(" + strings.Join(names, ",") + ")"
+	return c, nil
+}
+
+func (c *compositeConfigurer) Name() string {
+	return c.name
+}
+
+// Start starts all the backends, it fails only if no backend started.
+// Backends failed to start are retried in background.
+func (c *compositeConfigurer) Start() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var errs []error
+	for _, b := range c.backends {
+		if err := b.Start(); err != nil {
+			c.logger.Errorf("error start %s: %v", b.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %v", b.Name(), err))
+			continue
+		}
+		b.started = true
+	}
+	if len(errs) == len(c.backends) {
+		return multierr.Combine(errs...)
+	}
+	go c.retry()
+	return nil
+}
+
+func (c *compositeConfigurer) Stop() {
+	close(c.closeCh)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, b := range c.backends {
+		if b.started {
+			b.Stop()
+		}
+	}
+}
+
+// BootstrapCheck merges input files of all the backends. Keys of the result
+// are prefixed with backend name, since a container has an input file in
+// each backend. Backends failed the check are retried in background.
+func (c *compositeConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := make(map[string]*configurer.InputConfigFile)
+	for _, b := range c.backends {
+		if !b.started {
+			continue
+		}
+		files, err := b.BootstrapCheck()
+		if err != nil {
+			c.logger.Errorf("%s bootstrap check failed: %v", b.Name(), err)
+			continue
+		}
+		b.ready = true
+		for id, f := range files {
+			ret[b.Name()+"/"+id] = f
+		}
+	}
+	c.bootstrapped = true
+	return ret, nil
+}
+
+// OnAdd forwards the event to responsible backends, it fails only if all of
+// them failed. So that the container is still tracked and its destroy event
+// will be received by the backends which succeeded. Backends which failed
+// are retried in background.
+func (c *compositeConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	id := ev.Container.ID
+	var errs []error
+	total := 0
+	for _, b := range c.backends {
+		if !b.ready || !b.isResponsible(ev.Container.Namespace) {
+			continue
+		}
+		total++
+		if err := b.OnAdd(ev); err != nil {
+			c.logger.Errorw("Fail to handle container", append(ev.Container.LogFields(), "configurer", b.Name(), "error", err)...)
+			errs = append(errs, fmt.Errorf("%s: %v", b.Name(), err))
+			b.failed[id] = ev
+			continue
+		}
+		delete(b.failed, id)
+	}
+	if total > 0 && len(errs) == total {
+		if _, ok := c.containers[id]; !ok {
+			// Not tracked by discovery, no destroy event will stop the
+			// retries.
+			for _, b := range c.backends {
+				delete(b.failed, id)
+			}
+		}
+		return multierr.Combine(errs...)
+	}
+	c.containers[id] = ev
+	return nil
+}
+
+func (c *compositeConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.containers, ev.Container.ID)
+	var errs []error
+	for _, b := range c.backends {
+		delete(b.failed, ev.Container.ID)
+		if !b.ready || !b.isResponsible(ev.Container.Namespace) {
+			continue
+		}
+		if err := b.OnDestroy(ev); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", b.Name(), err))
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+func (c *compositeConfigurer) retry() {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			c.retryBackends()
+		}
+	}
+}
+
+// retryBackends starts backends which failed to start, checks input files
+// of the ones which failed the bootstrap check, and adds containers which
+// backends failed to add.
+func (c *compositeConfigurer) retryBackends() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, b := range c.backends {
+		if !b.started {
+			if err := b.Start(); err != nil {
+				c.logger.Warnf("error start %s: %v", b.Name(), err)
+				continue
+			}
+			c.logger.Infof("%s started", b.Name())
+			b.started = true
+		}
+		if !b.ready {
+			if !c.bootstrapped {
+				continue
+			}
+			if err := c.bootstrap(b); err != nil {
+				c.logger.Warnf("%s bootstrap check failed: %v", b.Name(), err)
+				continue
+			}
+		}
+		for id, ev := range b.failed {
+			if err := b.OnAdd(ev); err != nil {
+				c.logger.Warnw("Fail to handle container again", append(ev.Container.LogFields(), "configurer", b.Name(), "error", err)...)
+				continue
+			}
+			delete(b.failed, id)
+		}
+	}
+}
+
+// bootstrap checks input files of a backend which becomes ready after
+// discovery bootstrapped. Input files of containers not added are removed,
+// like discovery does at bootstrap, and the containers the backend is
+// responsible for are queued to be added.
+func (c *compositeConfigurer) bootstrap(b *backend) error {
+	files, err := b.BootstrapCheck()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, ok := c.containers[f.ContainerID]; ok {
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	b.ready = true
+	c.logger.Infof("%s bootstrap check done", b.Name())
+	for id, ev := range c.containers {
+		if b.isResponsible(ev.Container.Namespace) {
+			b.failed[id] = ev
+		}
+	}
+	return nil
+}
+
+// Migrate migrates input files of the backends which support migration.
+func (c *compositeConfigurer) Migrate(dryRun bool) ([]*configurer.Migration, error) {
+	ret := []*configurer.Migration{}
+	var errs []error
+	for _, b := range c.backends {
+		m, ok := b.Configurer.(configurer.Migrator)
+		if !ok {
+			continue
+		}
+		migrations, err := m.Migrate(dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", b.Name(), err))
+		}
+		ret = append(ret, migrations...)
+	}
+	return ret, multierr.Combine(errs...)
+}
+
+// Inspect merges states of containers tracked by the backends. Backends which
+// do not support inspection or are not ready report containers they are
+// responsible for, with the reason as error.
+func (c *compositeConfigurer) Inspect() ([]*configurer.ContainerState, error) {
+	type inspection struct {
+		inspector configurer.Inspector
+		name      string
+	}
+	c.lock.Lock()
+	inspections := []inspection{}
+	var unsupported []*configurer.ContainerState
+	for _, b := range c.backends {
+		i, ok := b.Configurer.(configurer.Inspector)
+		if ok && b.ready {
+			inspections = append(inspections, inspection{inspector: i, name: b.Name()})
+			continue
+		}
+		reason := b.Name() + " does not support inspection"
+		if ok {
+			reason = b.Name() + " is not ready"
+		}
+		for _, ev := range c.containers {
+			if !b.isResponsible(ev.Container.Namespace) {
+				continue
+			}
+			unsupported = append(unsupported, &configurer.ContainerState{
+				Configurer:  b.Name(),
+				ContainerID: ev.Container.ID,
+				Namespace:   ev.Container.Namespace,
+				Pod:         ev.Container.Pod,
+				PodID:       ev.Container.PodID,
+				Container:   ev.Container.Name,
+				LogConfigs:  ev.LogConfigs,
+				Error:       reason,
+			})
+		}
+	}
+	c.lock.Unlock()
+
+	// Backends are inspected without the lock, which would block events.
+	ret := []*configurer.ContainerState{}
+	var errs []error
+	for _, i := range inspections {
+		states, err := i.inspector.Inspect()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", i.name, err))
+			continue
+		}
+		ret = append(ret, states...)
+	}
+	return append(ret, unsupported...), multierr.Combine(errs...)
+}
+
+// NotifyLoss sets the function called on suspected loss detected by the
+// backends which support it.
+func (c *compositeConfigurer) NotifyLoss(fn func(loss *configurer.Loss)) {
+	for _, b := range c.backends {
+		if n, ok := b.Configurer.(configurer.LossNotifier); ok {
+			n.NotifyLoss(fn)
+		}
+	}
+}
+
+// Check checks all the backends, a backend which failed to start or failed
+// the bootstrap check is unhealthy.
+func (c *compositeConfigurer) Check() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var errs []error
+	for _, b := range c.backends {
+		if !b.started {
+			errs = append(errs, fmt.Errorf("%s: not started", b.Name()))
+			continue
+		}
+		if c.bootstrapped && !b.ready {
+			errs = append(errs, fmt.Errorf("%s: bootstrap check failed", b.Name()))
+			continue
+		}
+		if ck, ok := b.Configurer.(configurer.Checker); ok {
+			if err := ck.Check(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", b.Name(), err))
+			}
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+func listToSet(list []string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for i := range list {
+		set[list[i]] = struct{}{}
+	}
+	return set
+}
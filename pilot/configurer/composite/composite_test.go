@@ -0,0 +1,212 @@
+package composite
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+type fakeConfigurer struct {
+	name          string
+	fail          bool
+	failStart     bool
+	failBootstrap bool
+	added         []string
+	removed       []string
+}
+
+func (f *fakeConfigurer) Name() string { return f.name }
+func (f *fakeConfigurer) Start() error {
+	if f.failStart {
+		return fmt.Errorf("failed")
+	}
+	return nil
+}
+func (f *fakeConfigurer) Stop() {}
+func (f *fakeConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	if f.failBootstrap {
+		return nil, fmt.Errorf("failed")
+	}
+	return map[string]*configurer.InputConfigFile{
+		"1": &configurer.InputConfigFile{ContainerID: "1", Path: f.name + "/1.yml"},
+	}, nil
+}
+func (f *fakeConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	if f.fail {
+		return fmt.Errorf("failed")
+	}
+	f.added = append(f.added, ev.Container.ID)
+	return nil
+}
+func (f *fakeConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	f.removed = append(f.removed, ev.Container.ID)
+	return nil
+}
+
+func TestComposite(t *testing.T) {
+	a := &fakeConfigurer{name: "a"}
+	b := &fakeConfigurer{name: "b"}
+	c, err := New(
+		Backend{Configurer: a},
+		Backend{Configurer: b, WListNS: []string{"default"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := c.BootstrapCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expect 2 input files, got %v", files)
+	}
+
+	for _, ns := range []string{"default", "kube-system"} {
+		ev := &configurer.ContainerAddEvent{
+			Container: container.Container{ID: ns, Namespace: ns},
+		}
+		if err := c.OnAdd(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.added) != 2 {
+		t.Errorf("expect a to handle 2 containers, got %v", a.added)
+	}
+	if len(b.added) != 1 || b.added[0] != "default" {
+		t.Errorf("expect b to handle container in default only, got %v", b.added)
+	}
+
+	// A failing backend does not fail the event.
+	a.fail = true
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "x", Namespace: "default"},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Errorf("expect no error, got %v", err)
+	}
+	// Unless all the responsible backends failed.
+	ev.Container.Namespace = "kube-system"
+	if err := c.OnAdd(ev); err == nil {
+		t.Errorf("expect error")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	a := &fakeConfigurer{name: "a"}
+	b := &fakeConfigurer{name: "b", failStart: true}
+	d := &fakeConfigurer{name: "d", failBootstrap: true}
+	cfgr, err := New(Backend{Configurer: a}, Backend{Configurer: b}, Backend{Configurer: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfgr.(*compositeConfigurer)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	files, err := c.BootstrapCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expect input files of a only, got %v", files)
+	}
+	if err := c.Check(); err == nil {
+		t.Errorf("expect backends not ready to be unhealthy")
+	}
+
+	for _, id := range []string{"1", "2"} {
+		ev := &configurer.ContainerAddEvent{Container: container.Container{ID: id}}
+		a.fail = id == "2"
+		if err := c.OnAdd(ev); id == "1" && err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Container 2 failed in all the ready backends, it's not tracked.
+	if len(c.backends[0].failed) != 0 {
+		t.Errorf("expect no retry of untracked container, got %v", c.backends[0].failed)
+	}
+
+	a.fail = true
+	ev := &configurer.ContainerAddEvent{Container: container.Container{ID: "1"}}
+	if err := c.OnAdd(ev); err == nil {
+		t.Errorf("expect error")
+	}
+	a.fail, b.failStart, d.failBootstrap = false, false, false
+	c.retryBackends()
+
+	for _, f := range []*fakeConfigurer{a, b, d} {
+		if len(f.added) == 0 || f.added[len(f.added)-1] != "1" {
+			t.Errorf("expect %s to add container 1 again, got %v", f.name, f.added)
+		}
+	}
+	for _, b := range c.backends {
+		if !b.ready || len(b.failed) != 0 {
+			t.Errorf("expect %s ready without failed containers, got %v %v", b.Name(), b.ready, b.failed)
+		}
+	}
+	if err := c.Check(); err != nil {
+		t.Errorf("expect healthy, got %v", err)
+	}
+}
+
+type fakeInspector struct {
+	fakeConfigurer
+}
+
+func (f *fakeInspector) Inspect() ([]*configurer.ContainerState, error) {
+	ret := []*configurer.ContainerState{}
+	for _, id := range f.added {
+		ret = append(ret, &configurer.ContainerState{Configurer: f.name, ContainerID: id})
+	}
+	return ret, nil
+}
+
+func TestInspect(t *testing.T) {
+	a := &fakeInspector{fakeConfigurer{name: "a"}}
+	b := &fakeConfigurer{name: "b"}
+	c, err := New(Backend{Configurer: a}, Backend{Configurer: b, WListNS: []string{"default"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if _, err := c.BootstrapCheck(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ns := range []string{"default", "kube-system"} {
+		if err := c.OnAdd(&configurer.ContainerAddEvent{Container: container.Container{ID: ns, Namespace: ns}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, err := c.(configurer.Inspector).Inspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, s := range states {
+		got[s.Configurer+"/"+s.ContainerID] = s.Error
+	}
+	expect := map[string]string{
+		"a/default":     "",
+		"a/kube-system": "",
+		"b/default":     "b does not support inspection",
+	}
+	if len(got) != len(expect) {
+		t.Fatalf("expect states %v, got %v", expect, got)
+	}
+	for k, v := range expect {
+		if e, ok := got[k]; !ok || e != v {
+			t.Errorf("expect %s with error %q, got %q", k, v, e)
+		}
+	}
+}
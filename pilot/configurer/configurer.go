@@ -1,6 +1,10 @@
 package configurer
 
 import (
+	"io/ioutil"
+	"os"
+	"time"
+
 	"github.com/caicloud/log-pilot/pilot/container"
 )
 
@@ -9,6 +13,8 @@ type Configurer interface {
 	Name() string
 	Start() error
 	Stop()
+	// BootstrapCheck returns existing input files, keyed by an identifier
+	// unique among them, which is usually the container ID.
 	BootstrapCheck() (map[string]*InputConfigFile, error)
 	OnAdd(ev *ContainerAddEvent) error
 	OnDestroy(ev *ContainerDestroyEvent) error
@@ -26,16 +32,22 @@ type ContainerDestroyEvent struct {
 }
 
 type LogConfig struct {
-	Name string
+	Name string `json:"name"`
 	// LogFile is absolute path of the log file on host.
-	LogFile string
+	LogFile string `json:"logFile"`
 	// Format defines log format.
-	Format LogFormat
+	Format LogFormat `json:"format"`
 	// Tags are addtional informations that will be added to log record.
 	// For example, pod informations, user defined tags.
-	Tags   map[string]string
-	InOpts map[string]string
-	Stdout bool
+	Tags   map[string]string `json:"tags,omitempty"`
+	InOpts map[string]string `json:"inOpts,omitempty"`
+	// OutOpts is never set, it's kept for templates written for old
+	// versions which still reference it.
+	OutOpts map[string]string `json:"-"`
+	Stdout  bool              `json:"stdout,omitempty"`
+	// Profile selects the template to render the log, the template of
+	// Format is used if it's empty or has no template.
+	Profile string `json:"profile,omitempty"`
 }
 
 type LogFormat string
@@ -45,12 +57,97 @@ const (
 	LogFormatPlain = "plain"
 )
 
+// InputConfigFile describes an input file generated for a container. Except
+// Path, the fields are embedded in the file as a metadata header.
 type InputConfigFile struct {
-	Namespace   string
-	Pod         string
-	Container   string
-	ContainerID string
-	Version     string
+	Namespace   string `json:"namespace"`
+	Pod         string `json:"pod"`
+	PodID       string `json:"podUID,omitempty"`
+	Container   string `json:"container"`
+	ContainerID string `json:"containerID"`
+	Version     string `json:"version"`
+	// Hash is the sha256 of the file content following the header.
+	Hash    string    `json:"hash,omitempty"`
+	Created time.Time `json:"created"`
+	// LogFiles are log file patterns collected by the input file.
+	LogFiles []string `json:"logFiles,omitempty"`
+	// LogConfigs are used to render the file again when migrating to a
+	// new version.
+	LogConfigs []*LogConfig `json:"logConfigs,omitempty"`
 	// Absolute filepath.
-	Path string
+	Path string `json:"-"`
+}
+
+// Renderer renders input configs without writing them, it's used to debug
+// configurations.
+type Renderer interface {
+	Render(ev *ContainerAddEvent) (string, error)
+}
+
+// Migrator is implemented by configurers which upgrade input files of old
+// versions in place.
+type Migrator interface {
+	// Migrate upgrades input files of old versions and reports what is
+	// done, nothing is changed if dryRun is true.
+	Migrate(dryRun bool) ([]*Migration, error)
+}
+
+// Migration actions.
+const (
+	MigrationRerender = "rerender"
+	MigrationRemove   = "remove"
+)
+
+// Migration is the result of migrating an input file.
+type Migration struct {
+	Path        string
+	ContainerID string
+	From        string
+	To          string
+	Action      string
+	// Error is the reason if the file can't be migrated.
+	Error string
+}
+
+// Checker is implemented by configurers which check their health, e.g.
+// whether input files can be written.
+type Checker interface {
+	Check() error
+}
+
+// CheckWritable checks whether files can be created in the directory. The
+// file is hidden so that it's not loaded by log shippers.
+func CheckWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".log-pilot-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Reasons of log loss.
+const (
+	// LossTruncated means a log file was truncated before shipped.
+	LossTruncated = "truncated"
+	// LossRemoved means a log file was removed or rotated away before
+	// shipped.
+	LossRemoved = "removed"
+)
+
+// Loss is suspected loss of logs in a file of the container.
+type Loss struct {
+	Container container.Container
+	File      string
+	Reason    string
+	// Bytes is the estimated number of bytes lost, it's 0 if unknown.
+	Bytes int64
+}
+
+// LossNotifier is implemented by configurers which detect log loss by
+// comparing progress of shipping with log files.
+type LossNotifier interface {
+	// NotifyLoss sets the function called on suspected loss, it must not
+	// block.
+	NotifyLoss(fn func(loss *Loss))
 }
@@ -2,9 +2,7 @@ package filebeat
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -16,53 +14,100 @@ import (
 	"github.com/caicloud/log-pilot/pilot/container"
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/fileutil"
 	"github.com/caicloud/log-pilot/pilot/log"
 
-	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/go-ucfg"
 )
 
-// logStates contains states in filebeat registry and related to the container
-type logStates struct {
-	*container.Container
-	states []RegistryState
-	ts     time.Time
-}
-
 type filebeatConfigurer struct {
 	name string
 	base string
 	// Filebeat home path.
-	filebeatHome   string
-	tmpl           *template.Template
+	filebeatHome string
+	version      filebeatVersion
+	// templatePath is a template file, or a directory of template sets.
+	templatePath string
+	tmpl         *template.Template
+	// profiles are templates keyed by profile name.
+	profiles       map[string]*template.Template
 	closeCh        chan bool
-	watchDuration  time.Duration
+	gcPolicy       configurer.GCPolicy
 	watchContainer map[string]*logStates
-	logger         log.Logger
-	lock           sync.Mutex
+	// kickCh triggers a scan when a container is destroyed.
+	kickCh chan struct{}
+	// registry caches states read from filebeat registry, it's guarded by
+	// registryLock instead of lock, so the registry can be read without
+	// blocking events.
+	registry     registryReader
+	registryLock sync.Mutex
+	// containers saves the latest add event of running containers.
+	containers map[string]*configurer.ContainerAddEvent
+	// paths records input files found in bootstrap by container ID, files
+	// migrated from old versions keep their names.
+	paths map[string]string
+	// hashes records content hash of input files, keyed by file path. It
+	// avoids rewriting unchanged files, which triggers filebeat to reload.
+	hashes map[string]string
+	// growth tracks write rate of log files, and lags are the last exported
+	// collection lag. observed are log files seen when updating lag keyed by
+	// inode, used to detect loss. They are guarded by lagLock, the registry
+	// is read and log files are stated without holding lock.
+	lagInterval time.Duration
+	growth      map[string]*fileGrowth
+	lags        map[lagKey]*lag
+	observed    map[FileInode]*fileObservation
+	lagLock     sync.Mutex
+	// onLoss is called on suspected loss.
+	onLoss func(loss *configurer.Loss)
+	logger log.Logger
+	lock   sync.Mutex
 }
 
-// New creates a new filebeat configurer.
-func New(baseDir, configTemplateFile, filebeatHome string) (configurer.Configurer, error) {
-	t, err := template.ParseFiles(configTemplateFile)
+// New creates a new filebeat configurer. configTemplate is either a template
+// file, or a directory of template sets for different filebeat versions.
+// Collection lag is updated every lagInterval.
+func New(baseDir, configTemplate, filebeatHome, filebeatVersion string, gcPolicy configurer.GCPolicy, lagInterval time.Duration) (configurer.Configurer, error) {
+	version, err := parseFilebeatVersion(filebeatVersion)
 	if err != nil {
-		return nil, fmt.Errorf("error parse log template: %v", err)
+		return nil, err
+	}
+	if lagInterval <= 0 {
+		return nil, fmt.Errorf("lag interval must be positive")
 	}
 
 	if _, err := os.Stat(filebeatHome); err != nil {
 		return nil, err
 	}
 
-	logger := logp.NewLogger("configurer")
 	c := &filebeatConfigurer{
-		logger:         logger,
+		logger:         log.NewLogger("configurer"),
 		name:           "filebeat",
 		filebeatHome:   filebeatHome,
+		version:        version,
 		base:           baseDir,
-		tmpl:           t,
+		templatePath:   configTemplate,
 		closeCh:        make(chan bool),
+		kickCh:         make(chan struct{}, 1),
 		watchContainer: make(map[string]*logStates, 0),
-		watchDuration:  60 * time.Second,
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		gcPolicy:       gcPolicy,
+		lagInterval:    lagInterval,
+		paths:          make(map[string]string),
+		hashes:         make(map[string]string),
+	}
+
+	t, configTemplateFile, err := c.loadTemplate()
+	if err != nil {
+		return nil, err
+	}
+	c.tmpl = t
+	c.logger.Infof("Use template %s for filebeat %v", configTemplateFile, version)
+	if c.profiles, err = c.loadProfiles(); err != nil {
+		return nil, err
+	}
+	for profile := range c.profiles {
+		c.logger.Infof("Use template profile %s", profile)
 	}
 
 	if err := os.MkdirAll(c.getInputsDir(), 0644); err != nil {
@@ -78,6 +123,16 @@ func (c *filebeatConfigurer) Start() error {
 			c.logger.Errorf("error watch: %v", err)
 		}
 	}()
+	go func() {
+		if err := c.watchTemplate(); err != nil {
+			c.logger.Errorf("error watch template: %v", err)
+		}
+	}()
+	go func() {
+		if err := c.watchLag(); err != nil {
+			c.logger.Errorf("error watch collection lag: %v", err)
+		}
+	}()
 	return nil
 }
 
@@ -114,243 +169,208 @@ func (c *filebeatConfigurer) getRegistryFile() string {
 // BootstrapCheck get called when we bootstrap. It removes unknown files,
 // update old version config to new version. And return all the input files.
 func (c *filebeatConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
-	inputConfDir := c.getInputsDir()
-	files, err := ioutil.ReadDir(inputConfDir)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	migrations, err := c.migrate(false)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error migrate input files: %v", err)
 	}
-
-	toRemove := []string{}
-	ret := make(map[string]*configurer.InputConfigFile)
-	for i := range files {
-		base := files[i].Name()
-		inputConfig, err := loadInput(base)
-		if err != nil {
-			log.Warnf("unable to load input config %s: %v", base, err)
-			toRemove = append(toRemove, base)
+	for _, m := range migrations {
+		if m.Error != "" {
+			c.logger.Warnf("unable to migrate %s from %s: %s", m.Path, m.From, m.Error)
 			continue
 		}
-		// Just remove old version for now.
-		if inputConfig.Version != currentInputConfigVersion {
-			log.Infof("old version: %s", base)
-			toRemove = append(toRemove, base)
-			continue
-		}
-		inputConfig.Path = filepath.Join(inputConfDir, base)
-		ret[inputConfig.ContainerID] = inputConfig
+		c.logger.Infof("migrated %s from %s to %s", m.Path, m.From, m.To)
 	}
 
-	for _, base := range toRemove {
-		if err := os.Remove(filepath.Join(inputConfDir, base)); err != nil {
-			return nil, err
-		}
-	}
-	return ret, nil
-}
-
-// <namespace>_<pod>_<container_name>_<container_id>_<version>.yml
-func loadInput(base string) (*configurer.InputConfigFile, error) {
-	if !strings.HasSuffix(base, ".yml") {
-		return nil, fmt.Errorf("filename does not end with .yml")
+	// Files of old versions left are the ones migrate failed on.
+	ret, err := configurer.LoadInputDir(c.getInputsDir(), currentInputConfigVersion, c.logger)
+	if err != nil {
+		return nil, err
 	}
-	name := base[:len(base)-4]
-	items := strings.Split(name, "_")
-	if len(items) != 5 {
-		return nil, fmt.Errorf("invalid filename pattern: %v", name)
+	for _, inputConfig := range ret {
+		// Keep using the file whatever its name is, filebeat identifies
+		// inputs by path.
+		c.paths[inputConfig.ContainerID] = inputConfig.Path
+		c.hashes[inputConfig.Path] = inputConfig.Hash
 	}
-
-	return &configurer.InputConfigFile{
-		Namespace:   items[0],
-		Pod:         items[1],
-		Container:   items[2],
-		ContainerID: items[3],
-		Version:     items[4],
-	}, nil
+	return ret, nil
 }
 
 func (c *filebeatConfigurer) getContainerConfigPath(con *container.Container) string {
-	base := strings.Join([]string{con.Namespace, con.Pod, con.Name, con.ID, currentInputConfigVersion}, "_")
-	base = base + ".yml"
-	return filepath.Join(c.getInputsDir(), base)
+	if path, ok := c.paths[con.ID]; ok {
+		return path
+	}
+	return filepath.Join(c.getInputsDir(), configurer.InputFilename(con, currentInputConfigVersion))
 }
 
-func (c *filebeatConfigurer) watch() error {
-	c.logger.Infof("%s watcher start", c.Name())
-	for {
-		select {
-		case <-c.closeCh:
-			c.logger.Infof("%s watcher stop", c.Name())
-			return nil
-		case <-time.After(c.watchDuration):
-			c.logger.Infof("%s watcher scan", c.Name())
-
-			startTs := time.Now()
-			err := c.scan()
-			c.logger.Debugf("cost %v to complete scan", time.Since(startTs))
-			if err != nil {
-				c.logger.Errorf("%s watcher scan error: %v", c.Name(), err)
-			}
+func (c *filebeatConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 
-		}
-	}
+	// Keep the event even if it fails, the input file is written again when
+	// the template is reloaded.
+	c.containers[ev.Container.ID] = ev
+	return c.writeInput(ev)
 }
 
-// scan gc for input files
-func (c *filebeatConfigurer) scan() error {
-	states, err := c.getRegsitryState()
+// writeInput renders and writes the input file of the container, it skips
+// writing if the content is unchanged.
+func (c *filebeatConfigurer) writeInput(ev *configurer.ContainerAddEvent) error {
+	content, err := c.render(ev)
 	if err != nil {
-		return nil
+		configurer.RenderErrors.WithLabelValues(c.Name()).Inc()
+		return fmt.Errorf("error render config file: %v", err)
 	}
 
-	c.lock.Lock()
-	defer c.lock.Unlock()
+	// Filebeat drops the whole file if it is invalid.
+	if _, err := checkInputs(content); err != nil {
+		configurer.RenderErrors.WithLabelValues(c.Name()).Inc()
+		return fmt.Errorf("invalid config file rendered: %v", err)
+	}
 
-	c.logger.Debugf("watching containers: %#v", c.watchContainer)
-
-	for container, lst := range c.watchContainer {
-		confPath := c.getContainerConfigPath(lst.Container)
-		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
-			c.logger.Infof("log config %s.yml has been removed and ignore", container)
-			delete(c.watchContainer, container)
-		} else if c.canRemoveConf(container, states, lst) {
-			c.logger.Infof("try to remove log config %s.yml", container)
-			if err := os.Remove(confPath); err != nil {
-				c.logger.Errorf("remove log config %s.yml fail: %v", container, err)
-			} else {
-				delete(c.watchContainer, container)
-			}
-		} else {
-			c.logger.Debugf("%s.yml cannot be removed for now, will try to remove it in next scan", container)
+	confPath := c.getContainerConfigPath(&ev.Container)
+	meta := configurer.NewInputConfigFile(ev, currentInputConfigVersion, []byte(content))
+	if c.hashes[confPath] == meta.Hash {
+		if _, err := os.Stat(confPath); err == nil {
+			c.logger.Debugw("Configuration unchanged", ev.Container.LogFields()...)
+			return nil
 		}
 	}
-	return nil
-}
+	// Created is when the container's input was first written.
+	if old, err := configurer.ReadInputConfigFile(confPath); err == nil && old != nil && !old.Created.IsZero() {
+		meta.Created = old.Created
+	}
+	data, err := meta.Encode([]byte(content))
+	if err != nil {
+		return fmt.Errorf("error encode config file: %v", err)
+	}
 
-var (
-	zeroTime time.Time
-)
+	// Write via a temporary file, filebeat may reload inputs at any time and
+	// must not see a truncated file.
+	if err := fileutil.WriteFileAtomic(confPath, data, 0644); err != nil {
+		return fmt.Errorf("error write config file: %v", err)
+	}
+	c.hashes[confPath] = meta.Hash
+	configurer.InputsWritten.WithLabelValues(c.Name()).Inc()
 
-func getLogDirPrefix(base, podID string) string {
-	return filepath.Join(base, fmt.Sprintf("/var/lib/kubelet/pods/%s/volumes/kubernetes.io~empty-dir", podID))
+	c.logger.Infow("Configuration updated successfully", append(ev.Container.LogFields(), "path", confPath)...)
+	return nil
 }
 
-// 检查已删除容器 input 文件是否可以移除
-// 先根据用 pod id 生成唯一路径前缀，然后从 registry file 中找到相应的 states
-// 如果是第一次检查，更新 logStates 并返回 false
-// 否则和上一次检查对比，如果 states 有变化说明日志还没采集完, 更新 logStates 并返回 false，若没有变化则返回 true
-func (c *filebeatConfigurer) canRemoveConf(container string, registry map[string]RegistryState, lst *logStates) bool {
-	logDirPrefix := getLogDirPrefix(c.base, lst.PodID)
-	c.logger.Debug("LogDir prefix:", logDirPrefix)
-
-	// Find stats belong to the same pod
-	var states []RegistryState
-	for source, rs := range registry {
-		if strings.HasPrefix(source, logDirPrefix) {
-			c.logger.Debug("found match state:", source)
-			states = append(states, rs)
-		}
+// NewRenderer creates a configurer which only renders input configs, it
+// doesn't require filebeat home.
+func NewRenderer(configTemplate, filebeatVersion string) (configurer.Renderer, error) {
+	version, err := parseFilebeatVersion(filebeatVersion)
+	if err != nil {
+		return nil, err
 	}
-
-	// Sort states by source
-	bySource := func(i, j int) bool {
-		return states[i].Source < states[j].Source
+	c := &filebeatConfigurer{
+		logger:       log.NewLogger("configurer"),
+		name:         "filebeat",
+		version:      version,
+		templatePath: configTemplate,
 	}
-	sort.Slice(states, bySource)
-
-	if zeroTime == lst.ts {
-		// First time check
-		c.logger.Debugf("check %s.yml for the first time, states: %#v", container, states)
-		lst.states = states
-		lst.ts = time.Now()
-
-		if len(states) == 0 {
-			return true
-		}
-		return false
-	}
-
-	c.logger.Debugf("check %s.yml, old states: %#v, new states: %#v", container, lst.states, states)
-
-	// Check if states changed
-	changed := false
-	if len(states) != len(lst.states) {
-		changed = true
-	} else {
-		for i := range states {
-			if states[i].Source == lst.states[i].Source &&
-				states[i].FileStateOS.Device == lst.states[i].FileStateOS.Device &&
-				states[i].FileStateOS.Inode == lst.states[i].FileStateOS.Inode &&
-				states[i].Offset == lst.states[i].Offset {
-				continue
-			} else {
-				changed = true
-				break
-			}
-		}
+	if c.tmpl, _, err = c.loadTemplate(); err != nil {
+		return nil, err
 	}
-
-	if changed == true {
-		// Update states, keep it and wait for next check
-		lst.states = states
-		lst.ts = time.Now()
-		c.logger.Debugf("inputs for container %s cannot be removed for now due to states changed")
-		return false
+	if c.profiles, err = c.loadProfiles(); err != nil {
+		return nil, err
 	}
-
-	return true
+	return c, nil
 }
 
-func (c *filebeatConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
-	c.lock.Lock()
-	defer c.lock.Unlock()
+// NewMigrator creates a filebeat configurer which only migrates input files in
+// the filebeat home. Unlike New, it writes nothing until Migrate is called
+// without dry run.
+func NewMigrator(configTemplate, filebeatHome, filebeatVersion string) (configurer.Migrator, error) {
+	r, err := NewRenderer(configTemplate, filebeatVersion)
+	if err != nil {
+		return nil, err
+	}
+	c := r.(*filebeatConfigurer)
+	c.filebeatHome = filebeatHome
+	return c, nil
+}
 
+// Render renders and checks the input config of the container.
+func (c *filebeatConfigurer) Render(ev *configurer.ContainerAddEvent) (string, error) {
 	content, err := c.render(ev)
 	if err != nil {
-		return fmt.Errorf("error render config file: %v", err)
+		return "", err
 	}
+	if _, err := checkInputs(content); err != nil {
+		return content, err
+	}
+	return content, nil
+}
 
-	confPath := c.getContainerConfigPath(&ev.Container)
-	if err := ioutil.WriteFile(confPath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("error write config file: %v", err)
+// render renders logs of the container with templates of their profiles, and
+// the default template for the rest.
+func (c *filebeatConfigurer) render(ev *configurer.ContainerAddEvent) (string, error) {
+	groups := map[string][]*configurer.LogConfig{}
+	for _, cfg := range ev.LogConfigs {
+		profile := c.profileOf(cfg)
+		groups[profile] = append(groups[profile], cfg)
+	}
+	if len(groups) <= 1 && len(groups[""]) == len(ev.LogConfigs) {
+		return renderTemplate(c.tmpl, ev, c.version)
 	}
 
-	c.logger.Info("Configuration updated successfully for container", ev.Container.ID)
-	return nil
+	profiles := make([]string, 0, len(groups))
+	for profile := range groups {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+
+	var buf bytes.Buffer
+	for _, profile := range profiles {
+		t := c.tmpl
+		if profile != "" {
+			t = c.profiles[profile]
+		}
+		content, err := renderTemplate(t, &configurer.ContainerAddEvent{
+			Container:  ev.Container,
+			LogConfigs: groups[profile],
+		}, c.version)
+		if err != nil {
+			return "", fmt.Errorf("error render profile %q: %v", profile, err)
+		}
+		buf.WriteString(content)
+		if !strings.HasSuffix(content, "\n") {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String(), nil
 }
 
-func (c *filebeatConfigurer) render(ev *configurer.ContainerAddEvent) (string, error) {
+func renderTemplate(t *template.Template, ev *configurer.ContainerAddEvent, version filebeatVersion) (string, error) {
 	var buf bytes.Buffer
 	context := map[string]interface{}{
-		"containerId": ev.Container.ID,
-		"configList":  ev.LogConfigs,
+		"containerId":     ev.Container.ID,
+		"configList":      ev.LogConfigs,
+		"filebeatVersion": version.String(),
 	}
-	if err := c.tmpl.Execute(&buf, context); err != nil {
+	if err := t.Execute(&buf, context); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
 }
 
+// getRegsitryState returns file states in registry. The returned map is
+// shared by callers and must not be modified.
 func (c *filebeatConfigurer) getRegsitryState() (map[string]RegistryState, error) {
-	f, err := os.Open(c.getRegistryFile())
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	decoder := json.NewDecoder(f)
-	states := make([]RegistryState, 0)
-	err = decoder.Decode(&states)
-	if err != nil {
-		return nil, err
-	}
-
-	statesMap := make(map[string]RegistryState, 0)
-	for _, state := range states {
-		if _, ok := statesMap[state.Source]; !ok {
-			statesMap[state.Source] = state
+	c.registryLock.Lock()
+	defer c.registryLock.Unlock()
+	if c.registry == nil {
+		reader, err := newRegistryReader(c.getRegistryFile())
+		if err != nil {
+			return nil, err
 		}
+		c.registry = reader
 	}
-	return statesMap, nil
+	return c.registry.Read()
 }
 
 func (c *filebeatConfigurer) Name() string {
@@ -362,13 +382,41 @@ func (c *filebeatConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) err
 	defer c.lock.Unlock()
 
 	if _, ok := c.watchContainer[ev.Container.ID]; !ok {
-		c.watchContainer[ev.Container.ID] = &logStates{
+		lst := &logStates{
 			Container: &ev.Container,
+			destroyed: time.Now(),
+		}
+		if added, ok := c.containers[ev.Container.ID]; ok {
+			lst.logConfigs = added.LogConfigs
 		}
+		c.watchContainer[ev.Container.ID] = lst
 	}
+	delete(c.containers, ev.Container.ID)
+	configurer.GCPendingContainers.WithLabelValues(c.Name()).Set(float64(len(c.watchContainer)))
+	c.kick()
 	return nil
 }
 
 func (c *filebeatConfigurer) Stop() {
 	close(c.closeCh)
 }
+
+// Profiles returns names of profile templates which can be used by logs with
+// the template and filebeat version.
+func Profiles(configTemplate, filebeatVersion string) ([]string, error) {
+	r, err := NewRenderer(configTemplate, filebeatVersion)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for profile := range r.(*filebeatConfigurer).profiles {
+		ret = append(ret, profile)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Check checks input files can be written.
+func (c *filebeatConfigurer) Check() error {
+	return configurer.CheckWritable(c.getInputsDir())
+}
@@ -2,7 +2,7 @@
 - type: log
   enabled: true
   paths:
-      - {{ .LogFile }}
+      - {{ quote .LogFile }}
   scan_frequency: 10s
   fields_under_root: true
   {{- if .Stdout}}
@@ -11,16 +11,16 @@
   {{- if eq .Format "json"}}
   json.keys_under_root: true
   {{end}}
-  {{- if ( (len .Tags) or (len .InOpts) or (len .OutOpts) ) }}
+  {{- if or .Tags .InOpts .OutOpts }}
   fields:
       {{- range $key, $value := .Tags}}
-      {{ $key }}: {{ $value }}
+      {{ quote $key }}: {{ quote $value }}
       {{end}}
       {{- range $key, $value := .InOpts}}
-      {{ $key }}: {{ $value }}
+      {{ quote $key }}: {{ quote $value }}
       {{end}}
       {{- range $key, $value := .OutOpts}}
-      {{ $key }}: {{ $value }}
+      {{ quote $key }}: {{ quote $value }}
       {{end}}
   {{- end -}}
   tail_files: false
@@ -1,12 +1,23 @@
 package filebeat
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/caicloud/log-pilot/pilot/container"
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -28,7 +39,7 @@ var (
 )
 
 func TestRender(t *testing.T) {
-	tmpl, err := template.ParseFiles("filebeat.tpl")
+	tmpl, err := parseTemplate("filebeat.tpl")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -54,3 +65,381 @@ func TestRender(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestOnAddSkipUnchanged(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+
+	ev := configurer.ContainerAddEvent{
+		Container: container.Container{
+			ID:        "1",
+			Name:      "app",
+			Namespace: "default",
+			Pod:       "app-0",
+		},
+	}
+	if err := c.OnAdd(&ev); err != nil {
+		t.Fatal(err)
+	}
+	confPath := c.getContainerConfigPath(&ev.Container)
+	old, err := os.Stat(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new configurer, as if log-pilot restarted.
+	c.hashes = make(map[string]string)
+	if _, err := c.BootstrapCheck(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := c.OnAdd(&ev); err != nil {
+		t.Fatal(err)
+	}
+	cur, err := os.Stat(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(old, cur) || !cur.ModTime().Equal(old.ModTime()) {
+		t.Errorf("unchanged input file should not be rewritten")
+	}
+}
+
+func TestOnAddKeepCreated(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	ev := configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1", Name: "app", Namespace: "default", Pod: "app-0"},
+	}
+	if err := c.OnAdd(&ev); err != nil {
+		t.Fatal(err)
+	}
+	confPath := c.getContainerConfigPath(&ev.Container)
+	meta, err := configurer.ReadInputConfigFile(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	meta.Created = created
+	body, err := configurer.ReadInputBody(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := meta.Encode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(confPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the file.
+	delete(c.hashes, confPath)
+	if err := c.OnAdd(&ev); err != nil {
+		t.Fatal(err)
+	}
+	meta, err = configurer.ReadInputConfigFile(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Created.Equal(created) {
+		t.Errorf("expect created %v kept, got %v", created, meta.Created)
+	}
+}
+
+func TestRenderTemplateSets(t *testing.T) {
+	cases := []struct {
+		version   string
+		template  string
+		inputType string
+	}{
+		{"6.5.3", "filebeat-6.tpl", "log"},
+		{"7.17", "filebeat-7.tpl", "filestream"},
+		{"8.11.0", "filebeat-7.tpl", "filestream"},
+	}
+
+	for _, cas := range cases {
+		v, err := parseFilebeatVersion(cas.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := selectTemplate("../../../assets/filebeat", v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(path) != cas.template {
+			t.Errorf("expect template %s for filebeat %s, got %s", cas.template, cas.version, path)
+			continue
+		}
+
+		c := &filebeatConfigurer{
+			tmpl:    template.Must(parseTemplate(path)),
+			version: v,
+		}
+		if err := c.validateTemplate(c.tmpl); err != nil {
+			t.Errorf("invalid template %s: %v", path, err)
+		}
+		content, err := c.render(&configurer.ContainerAddEvent{
+			Container: container.Container{ID: "1"},
+			LogConfigs: []*configurer.LogConfig{
+				&configurer.LogConfig{
+					Name:    "stdout",
+					LogFile: "/var/lib/docker/containers/1/1-json.log",
+					Format:  configurer.LogFormatJSON,
+					Tags:    map[string]string{"foo": "bar"},
+					Stdout:  true,
+				},
+				&configurer.LogConfig{
+					Name:    "access",
+					LogFile: "/opt/tomcat/access.log",
+					Format:  configurer.LogFormatJSON,
+					Tags:    map[string]string{"foo": "bar"},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var inputs []map[string]interface{}
+		if err := yaml.Unmarshal([]byte(content), &inputs); err != nil {
+			t.Fatalf("invalid yaml for filebeat %s: %v\n%s", cas.version, err, content)
+		}
+		if len(inputs) != 2 {
+			t.Fatalf("expect 2 inputs, got %d", len(inputs))
+		}
+		ids := map[interface{}]bool{}
+		for _, input := range inputs {
+			if input["type"] != cas.inputType {
+				t.Errorf("expect input type %s, got %v", cas.inputType, input["type"])
+			}
+			if cas.inputType == "filestream" {
+				if input["id"] == nil || ids[input["id"]] {
+					t.Errorf("filestream input requires an unique id, got %v", input["id"])
+				}
+				ids[input["id"]] = true
+			}
+		}
+	}
+}
+
+// newTestConfigurer creates a configurer with inline template.
+func newTestConfigurer(t *testing.T, home, tmpl string) *filebeatConfigurer {
+	c := &filebeatConfigurer{
+		filebeatHome:   home,
+		tmpl:           template.Must(template.New("").Funcs(funcMap).Parse(tmpl)),
+		gcPolicy:       configurer.DefaultGCPolicy(),
+		watchContainer: make(map[string]*logStates),
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		paths:          make(map[string]string),
+		hashes:         make(map[string]string),
+		logger:         log.NewLogger("test"),
+	}
+	if err := os.MkdirAll(c.getInputsDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// writeRegistry writes a filebeat 6 registry with the offset of the log file.
+func writeRegistry(t *testing.T, c *filebeatConfigurer, path string, offset int64) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	data, _ := json.Marshal([]RegistryState{{
+		Source:      path,
+		Offset:      offset,
+		FileStateOS: FileInode{Inode: uint64(st.Ino), Device: uint64(st.Dev)},
+	}})
+	if err := os.MkdirAll(filepath.Dir(c.getRegistryFile()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.getRegistryFile(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tplPath := filepath.Join(dir, "filebeat.tpl")
+	writeTemplate := func(tpl string) {
+		if err := ioutil.WriteFile(tplPath, []byte(tpl), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTemplate("{{ range .configList }}\n- type: log\n  paths: [{{ .LogFile }}]\n{{ end }}")
+
+	cfgr, err := New(dir, tplPath, dir, "6.5", configurer.DefaultGCPolicy(), DefaultLagInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfgr.(*filebeatConfigurer)
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1", Name: "app", Namespace: "default", Pod: "app-0"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{Name: "access", LogFile: "/var/log/access.log"},
+		},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	confPath := c.getContainerConfigPath(&ev.Container)
+
+	// Not a list of inputs.
+	writeTemplate("type: log\n")
+	if err := c.reloadTemplate(); err == nil {
+		t.Errorf("expect invalid template rejected")
+	}
+	writeTemplate("{{ range .configList }}\n- paths: [{{ .LogFile }}]\n{{ end }}")
+	if err := c.reloadTemplate(); err == nil {
+		t.Errorf("expect template without input type rejected")
+	}
+
+	writeTemplate("{{ range .configList }}\n- type: log\n  paths: [{{ .LogFile }}]\n  tail_files: true\n{{ end }}")
+	if err := c.reloadTemplate(); err != nil {
+		t.Fatal(err)
+	}
+	body, err := configurer.ReadInputBody(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "tail_files: true") {
+		t.Errorf("expect input re-rendered with the new template, got\n%s", body)
+	}
+}
+
+func TestRenderEscaping(t *testing.T) {
+	tags := map[string]string{
+		"quote":     `say "hi"`,
+		"backslash": `C:\logs\`,
+		"newline":   "line1\nline2",
+		"colon":     "a: b # c",
+	}
+	for _, tpl := range []string{"filebeat-6.tpl", "filebeat-7.tpl"} {
+		c := &filebeatConfigurer{
+			tmpl: template.Must(parseTemplate(filepath.Join("../../../assets/filebeat", tpl))),
+		}
+		content, err := c.render(&configurer.ContainerAddEvent{
+			Container: container.Container{ID: "1"},
+			LogConfigs: []*configurer.LogConfig{
+				&configurer.LogConfig{
+					Name:    "access",
+					LogFile: "/var/log/access.log",
+					Format:  configurer.LogFormatPlain,
+					Tags:    tags,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := checkInputs(content); err != nil {
+			t.Fatalf("%s: %v\n%s", tpl, err, content)
+		}
+
+		var inputs []struct {
+			Fields map[string]string `yaml:"fields"`
+		}
+		if err := yaml.Unmarshal([]byte(content), &inputs); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tags {
+			if got := inputs[0].Fields[k]; got != v {
+				t.Errorf("%s: expect tag %s=%q, got %q", tpl, k, v, got)
+			}
+		}
+	}
+}
+
+func TestCheckInputs(t *testing.T) {
+	cases := []struct {
+		content string
+		valid   bool
+	}{
+		{"- type: log\n  paths: [/var/log/a.log]\n", true},
+		{"", true},
+		{"- type: log\n  fields:\n    a: \"b\n", false},
+		{"log\n", false},
+		{"- paths: [/var/log/a.log]\n", false},
+	}
+	for _, cas := range cases {
+		_, err := checkInputs(cas.content)
+		if (err == nil) != cas.valid {
+			t.Errorf("%q: expect valid %v, got %v", cas.content, cas.valid, err)
+		}
+	}
+}
+
+func TestRenderProfiles(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{Name: "stdout", LogFile: "/var/lib/docker/containers/1/1-json.log", Format: configurer.LogFormatJSON, Stdout: true},
+			&configurer.LogConfig{Name: "app", LogFile: "/var/log/app.log", Format: configurer.LogFormatPlain, Profile: "java"},
+			&configurer.LogConfig{Name: "unknown", LogFile: "/var/log/unknown.log", Format: configurer.LogFormatPlain, Profile: "unknown"},
+		},
+	}
+	for _, cas := range []struct {
+		version string
+		java    string
+	}{
+		{"6.5", "log"},
+		{"7.17", "filestream"},
+	} {
+		cfgr, err := New(home, "../../../assets/filebeat", home, cas.version, configurer.DefaultGCPolicy(), DefaultLagInterval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := cfgr.(*filebeatConfigurer)
+		if c.profileOf(ev.LogConfigs[1]) != "java" || c.profileOf(ev.LogConfigs[2]) != "" {
+			t.Errorf("%s: unexpected profiles %v", cas.version, c.profiles)
+		}
+
+		content, err := c.render(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var inputs []map[string]interface{}
+		if err := yaml.Unmarshal([]byte(content), &inputs); err != nil {
+			t.Fatalf("%s: %v\n%s", cas.version, err, content)
+		}
+		if len(inputs) != 3 {
+			t.Fatalf("%s: expect 3 inputs, got %d\n%s", cas.version, len(inputs), content)
+		}
+		multiline := 0
+		for _, input := range inputs {
+			if strings.Contains(fmt.Sprint(input), "multiline") {
+				multiline++
+				if input["type"] != cas.java {
+					t.Errorf("%s: expect java profile for %s input, got %v", cas.version, cas.java, input["type"])
+				}
+			}
+		}
+		if multiline != 1 {
+			t.Errorf("%s: expect 1 input rendered by java profile, got %d\n%s", cas.version, multiline, content)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package filebeat
+
+import (
+	"fmt"
+	"path/filepath"
+	"text/template"
+
+	"github.com/caicloud/log-pilot/pilot/configurer/funcs"
+
+	"github.com/elastic/go-ucfg"
+	"github.com/elastic/go-ucfg/yaml"
+)
+
+// funcMap contains functions available in templates.
+var funcMap = funcs.FuncMap()
+
+// parseTemplate parses the template file with funcMap.
+func parseTemplate(file string) (*template.Template, error) {
+	return template.New(filepath.Base(file)).Funcs(funcMap).ParseFiles(file)
+}
+
+// checkInputs checks the rendered content can be loaded by filebeat as a
+// list of inputs, and returns the number of inputs.
+func checkInputs(content string) (int, error) {
+	cfg, err := yaml.NewConfig([]byte(content), configOpts...)
+	if err != nil {
+		return 0, fmt.Errorf("error parse rendered config: %v", err)
+	}
+	var inputs []*ucfg.Config
+	if err := cfg.Unpack(&inputs); err != nil {
+		return 0, fmt.Errorf("rendered config is not a list of inputs: %v", err)
+	}
+	for i, input := range inputs {
+		if !input.HasField("type") {
+			return 0, fmt.Errorf("input %d has no type", i)
+		}
+	}
+	return len(inputs), nil
+}
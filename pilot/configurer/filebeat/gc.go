@@ -0,0 +1,348 @@
+package filebeat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"gopkg.in/fsnotify/fsnotify.v1"
+)
+
+// logStates contains a destroyed container and its log configs.
+type logStates struct {
+	*container.Container
+	logConfigs []*configurer.LogConfig
+	destroyed  time.Time
+}
+
+// registryDebounce is the delay to scan after registry changed, filebeat
+// may flush the registry many times in a second.
+const registryDebounce = time.Second
+
+// watch scans input files of destroyed containers when filebeat registry
+// changed, or a deadline of the GC policy is reached. It also scans every
+// ScanInterval in case registry events are missed. Offsets of files observed
+// for loss are tracked on registry changes too.
+func (c *filebeatConfigurer) watch() error {
+	c.logger.Infof("%s watcher start", c.Name())
+
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		c.logger.Warnf("error create registry watcher, fall back to polling: %v", err)
+	} else {
+		defer w.Close()
+		events, errors = w.Events, w.Errors
+	}
+	watched := map[string]bool{}
+	c.watchRegistry(w, watched)
+
+	ticker := time.NewTicker(c.gcPolicy.ScanInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(c.gcPolicy.ScanInterval)
+	defer deadline.Stop()
+	var debounce, trackDebounce <-chan time.Time
+
+	scan := func() {
+		startTs := time.Now()
+		next, err := c.scan()
+		c.logger.Debugf("cost %v to complete scan", time.Since(startTs))
+		if err != nil {
+			c.logger.Errorf("%s watcher scan error: %v", c.Name(), err)
+		}
+		if !next.IsZero() {
+			if !deadline.Stop() {
+				select {
+				case <-deadline.C:
+				default:
+				}
+			}
+			deadline.Reset(time.Until(next))
+		}
+	}
+
+	for {
+		select {
+		case <-c.closeCh:
+			c.logger.Infof("%s watcher stop", c.Name())
+			return nil
+		case ev := <-events:
+			if !strings.HasPrefix(ev.Name, c.getRegistryFile()) {
+				continue
+			}
+			c.watchRegistry(w, watched)
+			if debounce == nil && c.pending() {
+				debounce = time.After(registryDebounce)
+			}
+			if trackDebounce == nil {
+				trackDebounce = time.After(registryDebounce)
+			}
+		case err := <-errors:
+			c.logger.Warnf("registry watcher error: %v", err)
+		case <-c.kickCh:
+			if debounce == nil {
+				debounce = time.After(registryDebounce)
+			}
+		case <-debounce:
+			debounce = nil
+			scan()
+		case <-trackDebounce:
+			trackDebounce = nil
+			if err := c.trackOffsets(); err != nil {
+				c.logger.Warnf("error track offsets of observed files: %v", err)
+			}
+		case <-deadline.C:
+			scan()
+		case <-ticker.C:
+			c.logger.Infof("%s watcher scan", c.Name())
+			c.watchRegistry(w, watched)
+			scan()
+		}
+	}
+}
+
+// watchRegistry adds directories where filebeat writes registry to the
+// watcher. They may not exist before filebeat starts, so it's called again
+// until all of them are watched.
+func (c *filebeatConfigurer) watchRegistry(w *fsnotify.Watcher, watched map[string]bool) {
+	if w == nil {
+		return
+	}
+	// Filebeat 6 replaces data/registry by renaming, filebeat 7 appends to
+	// data/registry/filebeat/log.json.
+	dirs := []string{filepath.Dir(c.getRegistryFile()), filepath.Join(c.getRegistryFile(), "filebeat")}
+	for _, dir := range dirs {
+		if watched[dir] {
+			continue
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			c.logger.Warnf("error watch %s: %v", dir, err)
+			continue
+		}
+		c.logger.Infof("watching registry changes in %s", dir)
+		watched[dir] = true
+	}
+}
+
+// kick triggers a scan without blocking.
+func (c *filebeatConfigurer) kick() {
+	select {
+	case c.kickCh <- struct{}{}:
+	default:
+	}
+}
+
+// pending returns whether there are destroyed containers waiting for their
+// logs to be drained, registry changes are ignored otherwise.
+func (c *filebeatConfigurer) pending() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	for _, lst := range c.watchContainer {
+		if now.Sub(lst.destroyed) >= c.gcPolicy.MinLinger {
+			return true
+		}
+	}
+	return false
+}
+
+// gcCandidate is a destroyed container being checked by scan.
+type gcCandidate struct {
+	id       string
+	lst      *logStates
+	confPath string
+	// gone is set if the input file has been removed by others.
+	gone bool
+	// pending is the number of bytes not shipped, force is set if the input
+	// file is removed before they are shipped.
+	pending int64
+	force   bool
+}
+
+// scan gc for input files. It returns the time of next deadline, which is
+// zero if no container is being watched. Registry is only read when some
+// containers have lingered for MinLinger.
+// Destroyed containers are copied under lock, the registry and log files are
+// read without it, and the lock is taken again to remove input files.
+func (c *filebeatConfigurer) scan() (time.Time, error) {
+	c.lock.Lock()
+	candidates := make([]*gcCandidate, 0, len(c.watchContainer))
+	for id, lst := range c.watchContainer {
+		candidates = append(candidates, &gcCandidate{id: id, lst: lst, confPath: c.getContainerConfigPath(lst.Container)})
+	}
+	c.lock.Unlock()
+
+	var next time.Time
+	setNext := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	now := time.Now()
+	var gone, draining []*gcCandidate
+	for _, cand := range candidates {
+		if _, err := os.Stat(cand.confPath); err != nil && os.IsNotExist(err) {
+			cand.gone = true
+			gone = append(gone, cand)
+			continue
+		}
+		if lingered := cand.lst.destroyed.Add(c.gcPolicy.MinLinger); now.Before(lingered) {
+			setNext(lingered)
+			continue
+		}
+		draining = append(draining, cand)
+	}
+
+	var removing []*gcCandidate
+	var scanErr error
+	if len(draining) > 0 {
+		states, err := c.getRegsitryState()
+		if err != nil && os.IsNotExist(err) {
+			// Filebeat has not written the registry yet.
+			states, err = map[string]RegistryState{}, nil
+		}
+		if err != nil {
+			scanErr = fmt.Errorf("error read registry: %v", err)
+			draining = nil
+		}
+		for _, cand := range draining {
+			cand.pending = c.pendingBytes(cand.lst, states)
+			if cand.pending > 0 {
+				if now.Sub(cand.lst.destroyed) < c.gcPolicy.MaxDrain {
+					c.logger.Debugw("Log config cannot be removed for now", append(cand.lst.LogFields(), "pending_bytes", cand.pending)...)
+					setNext(cand.lst.destroyed.Add(c.gcPolicy.MaxDrain))
+					continue
+				}
+				cand.force = true
+			}
+			removing = append(removing, cand)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, cand := range append(gone, removing...) {
+		// The container may have been forgotten while the lock was released.
+		if c.watchContainer[cand.id] != cand.lst {
+			continue
+		}
+		c.removeInput(cand, now)
+	}
+	configurer.GCPendingContainers.WithLabelValues(c.Name()).Set(float64(len(c.watchContainer)))
+	return next, scanErr
+}
+
+// removeInput removes the input file of the destroyed container, and forgets
+// the container. It must be called with lock held.
+func (c *filebeatConfigurer) removeInput(cand *gcCandidate, now time.Time) {
+	lst := cand.lst
+	if cand.gone {
+		c.logger.Infow("Log config has been removed and ignore", lst.LogFields()...)
+	} else if err := os.Remove(cand.confPath); err != nil {
+		c.logger.Errorw("Fail to remove log config", append(lst.LogFields(), "path", cand.confPath, "error", err)...)
+		return
+	}
+	delete(c.watchContainer, cand.id)
+	delete(c.paths, cand.id)
+	delete(c.hashes, cand.confPath)
+	if cand.gone {
+		return
+	}
+
+	configurer.InputsRemoved.WithLabelValues(c.Name()).Inc()
+	if cand.force {
+		configurer.GCForceRemoved.WithLabelValues(c.Name()).Inc()
+		configurer.GCForceRemovedBytes.WithLabelValues(c.Name()).Add(float64(cand.pending))
+		c.logger.Warnw("Force removed log config before logs were shipped",
+			append(lst.LogFields(), "path", cand.confPath, "age", now.Sub(lst.destroyed), "pending_bytes", cand.pending)...)
+	} else {
+		configurer.GCRemoved.WithLabelValues(c.Name()).Inc()
+		c.logger.Infow("Removed log config after drained", append(lst.LogFields(), "path", cand.confPath)...)
+	}
+}
+
+func getLogDirPrefix(base, podID string) string {
+	return filepath.Join(base, fmt.Sprintf("/var/lib/kubelet/pods/%s/volumes/kubernetes.io~empty-dir", podID))
+}
+
+func getStdoutDirPrefix(base, containerID string) string {
+	return filepath.Join(base, "/var/lib/docker/containers", containerID)
+}
+
+// logFiles returns log files of the container, which are found by log
+// configs and sources in registry under the container's log directories.
+func (c *filebeatConfigurer) logFiles(lst *logStates, registry map[string]RegistryState) []string {
+	set := map[string]struct{}{}
+	for _, cfg := range lst.logConfigs {
+		matches, err := filepath.Glob(cfg.LogFile)
+		if err != nil {
+			c.logger.Warnf("invalid log file pattern %s: %v", cfg.LogFile, err)
+			continue
+		}
+		for _, m := range matches {
+			set[m] = struct{}{}
+		}
+	}
+
+	prefixes := []string{getStdoutDirPrefix(c.base, lst.ID) + "/"}
+	if lst.PodID != "" {
+		prefixes = append(prefixes, getLogDirPrefix(c.base, lst.PodID)+"/")
+	}
+	for source := range registry {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(source, prefix) {
+				set[source] = struct{}{}
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(set))
+	for f := range set {
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// pendingBytes returns the number of bytes not read by filebeat in log files
+// of the container. A file is drained if its offset in registry reached the
+// file size, files which do not exist any more are ignored.
+func (c *filebeatConfigurer) pendingBytes(lst *logStates, registry map[string]RegistryState) int64 {
+	var pending int64
+	for _, f := range c.logFiles(lst, registry) {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		size := fi.Size()
+		state, ok := registry[f]
+		if !ok || !sameInode(fi, state.FileStateOS) {
+			// Not harvested yet, or rotated and the new file is not
+			// harvested yet.
+			pending += size
+			continue
+		}
+		if state.Offset < size {
+			pending += size - state.Offset
+		}
+	}
+	return pending
+}
+
+func sameInode(fi os.FileInfo, inode FileInode) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	return uint64(st.Ino) == inode.Inode && uint64(st.Dev) == inode.Device
+}
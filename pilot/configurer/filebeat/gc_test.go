@@ -0,0 +1,104 @@
+package filebeat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScan(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	logFile := filepath.Join(home, "app.log")
+	if err := ioutil.WriteFile(logFile, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name      string
+		offset    int64
+		destroyed time.Duration
+		removed   bool
+		forced    bool
+	}{
+		{"linger", 10, 10 * time.Second, false, false},
+		{"drained", 10, 2 * time.Minute, true, false},
+		{"pending", 4, 2 * time.Minute, false, false},
+		{"deadline", 4, 25 * time.Hour, true, true},
+	}
+	for _, cas := range cases {
+		c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+		ev := &configurer.ContainerAddEvent{
+			Container: container.Container{ID: "1", Namespace: "default", Pod: "app", Name: "app"},
+			LogConfigs: []*configurer.LogConfig{
+				&configurer.LogConfig{Name: "app", LogFile: filepath.Join(home, "*.log")},
+			},
+		}
+		if err := c.OnAdd(ev); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: ev.Container}); err != nil {
+			t.Fatal(err)
+		}
+		c.watchContainer["1"].destroyed = time.Now().Add(-cas.destroyed)
+		writeRegistry(t, c, logFile, cas.offset)
+		forcedBefore := testutil.ToFloat64(configurer.GCForceRemoved.WithLabelValues(c.Name()))
+		bytesBefore := testutil.ToFloat64(configurer.GCForceRemovedBytes.WithLabelValues(c.Name()))
+
+		if _, err := c.scan(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := os.Stat(c.getContainerConfigPath(&ev.Container))
+		if removed := os.IsNotExist(err); removed != cas.removed {
+			t.Errorf("%s: expect removed %v, got %v", cas.name, cas.removed, removed)
+		}
+		if forced := testutil.ToFloat64(configurer.GCForceRemoved.WithLabelValues(c.Name())) > forcedBefore; forced != cas.forced {
+			t.Errorf("%s: expect forced %v, got %v", cas.name, cas.forced, forced)
+		}
+		if bytes := testutil.ToFloat64(configurer.GCForceRemovedBytes.WithLabelValues(c.Name())) - bytesBefore; cas.forced && bytes != float64(10-cas.offset) {
+			t.Errorf("%s: expect %d bytes not shipped, got %v", cas.name, 10-cas.offset, bytes)
+		}
+	}
+}
+
+func TestOnDestroyAfterUnchangedAdd(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1", Namespace: "default", Pod: "app", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{Name: "app", LogFile: filepath.Join(home, "*.log")},
+		},
+	}
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted configurer knows hashes of existing inputs from bootstrap.
+	restarted := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	restarted.hashes = c.hashes
+	if err := restarted.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.OnDestroy(&configurer.ContainerDestroyEvent{Container: ev.Container}); err != nil {
+		t.Fatal(err)
+	}
+	if lst := restarted.watchContainer["1"]; lst == nil || len(lst.logConfigs) != 1 {
+		t.Errorf("expect log configs of unchanged input kept for gc, got %+v", lst)
+	}
+}
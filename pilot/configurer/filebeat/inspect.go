@@ -0,0 +1,90 @@
+package filebeat
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+// Inspect reports running containers and destroyed containers waiting for
+// gc, with offsets of their log files in filebeat registry.
+func (c *filebeatConfigurer) Inspect() ([]*configurer.ContainerState, error) {
+	// Containers are copied under the lock, the registry is read and log
+	// files are stated after releasing it, which may take a while.
+	c.lock.Lock()
+	ret := []*configurer.ContainerState{}
+	lsts := []*logStates{}
+	for _, ev := range c.containers {
+		lst := &logStates{
+			Container:  &ev.Container,
+			logConfigs: ev.LogConfigs,
+		}
+		lsts = append(lsts, lst)
+		ret = append(ret, c.containerState(lst))
+	}
+	for _, lst := range c.watchContainer {
+		s := c.containerState(lst)
+		destroyed := lst.destroyed
+		s.Destroyed = &destroyed
+		lsts = append(lsts, lst)
+		ret = append(ret, s)
+	}
+	c.lock.Unlock()
+
+	registry, err := c.getRegsitryState()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error read registry: %v", err)
+		}
+		registry = map[string]RegistryState{}
+	}
+	for i, lst := range lsts {
+		ret[i].Files = c.fileStates(lst, registry)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Namespace != ret[j].Namespace {
+			return ret[i].Namespace < ret[j].Namespace
+		}
+		if ret[i].Pod != ret[j].Pod {
+			return ret[i].Pod < ret[j].Pod
+		}
+		return ret[i].ContainerID < ret[j].ContainerID
+	})
+	return ret, nil
+}
+
+func (c *filebeatConfigurer) containerState(lst *logStates) *configurer.ContainerState {
+	return &configurer.ContainerState{
+		Configurer:  c.Name(),
+		ContainerID: lst.ID,
+		Namespace:   lst.Namespace,
+		Pod:         lst.Pod,
+		PodID:       lst.PodID,
+		Container:   lst.Name,
+		LogConfigs:  lst.logConfigs,
+		InputFile:   c.getContainerConfigPath(lst.Container),
+	}
+}
+
+// fileStates returns log files of the container with their offsets in
+// registry, it does not need the lock.
+func (c *filebeatConfigurer) fileStates(lst *logStates, registry map[string]RegistryState) []*configurer.FileState {
+	var ret []*configurer.FileState
+	files := c.logFiles(lst, registry)
+	sort.Strings(files)
+	for _, f := range files {
+		fs := &configurer.FileState{Path: f, Offset: -1}
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		fs.Size = fi.Size()
+		if state, ok := registry[f]; ok && sameInode(fi, state.FileStateOS) {
+			fs.Offset = state.Offset
+		}
+		ret = append(ret, fs)
+	}
+	return ret
+}
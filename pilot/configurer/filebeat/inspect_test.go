@@ -0,0 +1,69 @@
+package filebeat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+func TestInspect(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	logDir := filepath.Join(home, "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.log", "b.log"} {
+		if err := ioutil.WriteFile(filepath.Join(logDir, name), []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRegistry(t, c, filepath.Join(logDir, "a.log"), 4)
+
+	for _, id := range []string{"1", "2"} {
+		ev := &configurer.ContainerAddEvent{
+			Container: container.Container{ID: id, Namespace: "default", Pod: "app-" + id, Name: "app"},
+			LogConfigs: []*configurer.LogConfig{
+				&configurer.LogConfig{Name: "app", LogFile: filepath.Join(logDir, "*.log")},
+			},
+		}
+		if err := c.OnAdd(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: container.Container{ID: "2", Namespace: "default", Pod: "app-2", Name: "app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	configurer.InspectHandler(c).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/containers?pod=app-2", nil))
+	states := []*configurer.ContainerState{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body.String())
+	}
+	if len(states) != 1 {
+		t.Fatalf("expect 1 container, got %d", len(states))
+	}
+	s := states[0]
+	if s.ContainerID != "2" || s.Destroyed == nil || s.InputFile != c.getContainerConfigPath(&container.Container{ID: "2", Namespace: "default", Pod: "app-2", Name: "app"}) {
+		t.Errorf("unexpected state %+v", s)
+	}
+	if len(s.Files) != 2 {
+		t.Fatalf("expect 2 files, got %d", len(s.Files))
+	}
+	if s.Files[0].Offset != 4 || s.Files[1].Offset != -1 || s.Files[1].Size != 10 {
+		t.Errorf("unexpected files %+v %+v", s.Files[0], s.Files[1])
+	}
+}
@@ -0,0 +1,184 @@
+package filebeat
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultLagInterval is the default interval to update collection lag.
+const DefaultLagInterval = 30 * time.Second
+
+var (
+	lagBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log_pilot_collection_lag_bytes",
+		Help: "Number of bytes in log files not shipped by filebeat yet.",
+	}, []string{"namespace", "pod", "container"})
+	lagSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log_pilot_collection_lag_seconds",
+		Help: "Estimated age of the oldest log not shipped by filebeat yet.",
+	}, []string{"namespace", "pod", "container"})
+	unharvestedFiles = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log_pilot_collection_unharvested_files",
+		Help: "Number of log files without state in filebeat registry, which are not counted in lag.",
+	}, []string{"namespace", "pod", "container"})
+)
+
+// fileGrowth tracks the write rate of a log file.
+type fileGrowth struct {
+	size int64
+	seen time.Time
+	// rate is the smoothed write rate in bytes per second.
+	rate float64
+}
+
+// lagKey is the label values of lag metrics. Containers restarted in place
+// share the key, lags of them are summed up.
+type lagKey struct {
+	namespace string
+	pod       string
+	container string
+}
+
+// lag is the collection lag of a container.
+type lag struct {
+	bytes   int64
+	seconds float64
+	// unharvested is the number of files filebeat has not opened yet.
+	unharvested int
+}
+
+// watchLag updates collection lag of containers every lagInterval.
+func (c *filebeatConfigurer) watchLag() error {
+	ticker := time.NewTicker(c.lagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			return nil
+		case <-ticker.C:
+			if err := c.updateLag(); err != nil {
+				c.logger.Warnf("error update collection lag: %v", err)
+			}
+		}
+	}
+}
+
+// updateLag computes unshipped bytes of running and destroyed containers, and
+// checks log files for loss since the last update.
+// The age of unshipped logs is estimated by the write rate of files, as if
+// bytes after the offset were written at a constant rate till the last
+// modification. Files without state in registry are counted separately, they
+// may be excluded by filebeat or wait for a harvester.
+func (c *filebeatConfigurer) updateLag() error {
+	c.lagLock.Lock()
+	defer c.lagLock.Unlock()
+
+	// Containers are copied under lock, which is not held while reading the
+	// registry and log files.
+	c.lock.Lock()
+	states := []*logStates{}
+	for _, ev := range c.containers {
+		states = append(states, &logStates{Container: &ev.Container, logConfigs: ev.LogConfigs})
+	}
+	for _, lst := range c.watchContainer {
+		states = append(states, lst)
+	}
+	c.lock.Unlock()
+
+	registry, err := c.getRegsitryState()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("error read registry: %v", err)
+		}
+		registry = map[string]RegistryState{}
+	}
+
+	now := time.Now()
+	growth := make(map[string]*fileGrowth)
+	lags := make(map[lagKey]*lag)
+	observed := make(map[FileInode]*fileObservation)
+	tracked := make(map[string]bool)
+	for _, lst := range states {
+		tracked[lst.ID] = true
+		key := lagKey{lst.Namespace, lst.Pod, lst.Name}
+		l, ok := lags[key]
+		if !ok {
+			l = &lag{}
+			lags[key] = l
+		}
+		for _, f := range c.logFiles(lst, registry) {
+			fi, err := os.Stat(f)
+			if err != nil {
+				continue
+			}
+			g := c.growth[f].update(fi.Size(), now)
+			growth[f] = g
+
+			var offset int64
+			state, harvested := registry[f]
+			harvested = harvested && sameInode(fi, state.FileStateOS)
+			if harvested {
+				offset = state.Offset
+			}
+			if inode, ok := inodeOf(fi); ok {
+				observed[inode] = c.observe(lst.Container, f, fi, offset)
+			}
+			if !harvested {
+				l.unharvested++
+				continue
+			}
+			pending := fi.Size() - offset
+			if pending <= 0 {
+				continue
+			}
+			l.bytes += pending
+			seconds := now.Sub(fi.ModTime()).Seconds()
+			if g.rate > 0 {
+				seconds += float64(pending) / g.rate
+			}
+			if seconds > l.seconds {
+				l.seconds = seconds
+			}
+		}
+	}
+
+	for key := range c.lags {
+		if _, ok := lags[key]; !ok {
+			lagBytes.DeleteLabelValues(key.namespace, key.pod, key.container)
+			lagSeconds.DeleteLabelValues(key.namespace, key.pod, key.container)
+			unharvestedFiles.DeleteLabelValues(key.namespace, key.pod, key.container)
+		}
+	}
+	for key, l := range lags {
+		lagBytes.WithLabelValues(key.namespace, key.pod, key.container).Set(float64(l.bytes))
+		lagSeconds.WithLabelValues(key.namespace, key.pod, key.container).Set(l.seconds)
+		unharvestedFiles.WithLabelValues(key.namespace, key.pod, key.container).Set(float64(l.unharvested))
+	}
+	c.checkRemoved(observed, tracked, registry)
+	c.growth = growth
+	c.lags = lags
+	c.observed = observed
+	return nil
+}
+
+// update returns the growth with a new sample of file size. The rate is
+// reset if the file is truncated.
+func (g *fileGrowth) update(size int64, now time.Time) *fileGrowth {
+	if g == nil || size < g.size {
+		return &fileGrowth{size: size, seen: now}
+	}
+	ret := &fileGrowth{size: size, seen: now, rate: g.rate}
+	if elapsed := now.Sub(g.seen).Seconds(); elapsed > 0 {
+		rate := float64(size-g.size) / elapsed
+		if g.rate == 0 {
+			ret.rate = rate
+		} else {
+			ret.rate = (g.rate + rate) / 2
+		}
+	}
+	return ret
+}
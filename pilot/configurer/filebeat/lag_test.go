@@ -0,0 +1,81 @@
+package filebeat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpdateLag(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	logFile := filepath.Join(home, "app.log")
+	if err := ioutil.WriteFile(logFile, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeRegistry(t, c, logFile, 4)
+	newFile := filepath.Join(home, "new.log")
+	if err := ioutil.WriteFile(newFile, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1", Namespace: "lag", Pod: "app-0", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{Name: "app", LogFile: logFile},
+			&configurer.LogConfig{Name: "new", LogFile: newFile},
+		},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(lagBytes.WithLabelValues("lag", "app-0", "app")); v != 6 {
+		t.Errorf("expect 6 bytes lag, got %v", v)
+	}
+	if v := testutil.ToFloat64(lagSeconds.WithLabelValues("lag", "app-0", "app")); v < 0 || v > 60 {
+		t.Errorf("unexpected lag seconds %v", v)
+	}
+	if v := testutil.ToFloat64(unharvestedFiles.WithLabelValues("lag", "app-0", "app")); v != 1 {
+		t.Errorf("expect 1 unharvested file, got %v", v)
+	}
+
+	delete(c.containers, "1")
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+	if lagBytes.DeleteLabelValues("lag", "app-0", "app") {
+		t.Errorf("expect lag of removed container deleted")
+	}
+}
+
+func TestFileGrowth(t *testing.T) {
+	now := time.Now()
+	var g *fileGrowth
+	g = g.update(100, now)
+	g = g.update(200, now.Add(10*time.Second))
+	if g.rate != 10 {
+		t.Errorf("expect rate 10, got %v", g.rate)
+	}
+	g = g.update(400, now.Add(20*time.Second))
+	if g.rate != 15 {
+		t.Errorf("expect smoothed rate 15, got %v", g.rate)
+	}
+	if g = g.update(50, now.Add(30*time.Second)); g.rate != 0 {
+		t.Errorf("expect rate reset after truncated, got %v", g.rate)
+	}
+}
@@ -0,0 +1,178 @@
+package filebeat
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	lossTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_suspected_loss_total",
+		Help: "Number of log files truncated or removed before shipped.",
+	}, []string{"namespace", "pod", "container", "reason"})
+	lossBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_suspected_loss_bytes_total",
+		Help: "Estimated number of bytes lost in log files truncated or removed before shipped.",
+	}, []string{"namespace", "pod", "container", "reason"})
+)
+
+// fileObservation is a log file seen when updating lag, files are tracked by
+// inode to detect loss between two observations.
+type fileObservation struct {
+	container *container.Container
+	path      string
+	size      int64
+	offset    int64
+	// truncated is set once truncation is reported, until filebeat resets
+	// the offset.
+	truncated bool
+}
+
+// NotifyLoss sets the function called on suspected log loss.
+func (c *filebeatConfigurer) NotifyLoss(fn func(loss *configurer.Loss)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onLoss = fn
+}
+
+func inodeOf(fi os.FileInfo) (FileInode, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileInode{}, false
+	}
+	return FileInode{Inode: uint64(st.Ino), Device: uint64(st.Dev)}, true
+}
+
+// observe compares the file with the last observation of its inode. The file
+// is truncated if it shrank, or the offset in registry is beyond its size,
+// bytes not shipped before truncation are lost. Nothing is reported if the
+// file was shipped completely.
+func (c *filebeatConfigurer) observe(cont *container.Container, path string, fi os.FileInfo, offset int64) *fileObservation {
+	inode, _ := inodeOf(fi)
+	prev := c.observed[inode]
+	cur := &fileObservation{
+		container: cont,
+		path:      path,
+		size:      fi.Size(),
+		offset:    offset,
+	}
+	if prev == nil {
+		return cur
+	}
+
+	if fi.Size() >= prev.size && offset <= fi.Size() {
+		return cur
+	}
+	if prev.truncated && offset > fi.Size() {
+		// Reported, filebeat has not noticed the truncation yet.
+		cur.truncated = true
+		return cur
+	}
+	cur.truncated = offset > fi.Size()
+	if lost := prev.size - prev.offset; lost > 0 {
+		c.reportLoss(cont, path, configurer.LossTruncated, lost)
+	}
+	return cur
+}
+
+// checkRemoved reports files observed last time which no longer exist, once
+// filebeat has closed and cleaned their states, the last offset seen is what
+// was shipped. Files still in registry are kept observing, filebeat may keep
+// reading them after rotation. Files of containers not tracked any more are
+// forgotten.
+func (c *filebeatConfigurer) checkRemoved(observed map[FileInode]*fileObservation, tracked map[string]bool, registry map[string]RegistryState) {
+	byInode := registryByInode(registry)
+	for inode, prev := range c.observed {
+		if _, ok := observed[inode]; ok || !tracked[prev.container.ID] {
+			continue
+		}
+		if fi, err := os.Stat(prev.path); err == nil {
+			if cur, _ := inodeOf(fi); cur == inode {
+				// Exists but is not matched by log configs any more.
+				continue
+			}
+		}
+
+		if state, ok := byInode[inode]; ok {
+			// Rotated or removed, but filebeat has not cleaned the state.
+			next := *prev
+			if state.Offset > next.offset {
+				next.offset = state.Offset
+			}
+			observed[inode] = &next
+			continue
+		}
+		if lost := prev.size - prev.offset; lost > 0 {
+			c.reportLoss(prev.container, prev.path, configurer.LossRemoved, lost)
+		}
+	}
+}
+
+// trackOffsets updates offsets of observed files from registry, it's called
+// when registry changed, so that the final offset of a file is seen before
+// filebeat cleans its state, even if the file is rotated and removed between
+// two updates of lag.
+func (c *filebeatConfigurer) trackOffsets() error {
+	c.lagLock.Lock()
+	defer c.lagLock.Unlock()
+	if len(c.observed) == 0 {
+		return nil
+	}
+
+	registry, err := c.getRegsitryState()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error read registry: %v", err)
+	}
+	byInode := registryByInode(registry)
+	for inode, obs := range c.observed {
+		if state, ok := byInode[inode]; ok && state.Offset > obs.offset {
+			obs.offset = state.Offset
+		}
+		if fi, err := os.Stat(obs.path); err == nil {
+			if cur, _ := inodeOf(fi); cur == inode && fi.Size() > obs.size {
+				obs.size = fi.Size()
+			}
+		}
+	}
+	return nil
+}
+
+func registryByInode(registry map[string]RegistryState) map[FileInode]RegistryState {
+	ret := make(map[FileInode]RegistryState, len(registry))
+	for _, state := range registry {
+		ret[state.FileStateOS] = state
+	}
+	return ret
+}
+
+// reportLoss reports suspected loss by metrics, and to the function set by
+// NotifyLoss. It must not be called with lock held.
+func (c *filebeatConfigurer) reportLoss(cont *container.Container, path, reason string, bytes int64) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	lossTotal.WithLabelValues(cont.Namespace, cont.Pod, cont.Name, reason).Inc()
+	lossBytes.WithLabelValues(cont.Namespace, cont.Pod, cont.Name, reason).Add(float64(bytes))
+	c.logger.Warnw("Suspected log loss", append(cont.LogFields(), "path", path, "reason", reason, "lost_bytes", bytes)...)
+	c.lock.Lock()
+	onLoss := c.onLoss
+	c.lock.Unlock()
+	if onLoss != nil {
+		onLoss(&configurer.Loss{
+			Container: *cont,
+			File:      path,
+			Reason:    reason,
+			Bytes:     bytes,
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package filebeat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDetectLoss(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	appLog := filepath.Join(home, "app.log")
+	auditLog := filepath.Join(home, "audit.log")
+	if err := ioutil.WriteFile(appLog, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(auditLog, []byte("01234567"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeRegistry(t, c, appLog, 4)
+
+	losses := map[string]*configurer.Loss{}
+	c.NotifyLoss(func(loss *configurer.Loss) {
+		if _, ok := losses[loss.File]; ok {
+			t.Errorf("loss of %s reported twice", loss.File)
+		}
+		losses[loss.File] = loss
+	})
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1", Namespace: "loss", Pod: "app-0", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{Name: "app", LogFile: appLog},
+			&configurer.LogConfig{Name: "audit", LogFile: auditLog},
+		},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+	if len(losses) != 0 {
+		t.Fatalf("expect no loss, got %v", losses)
+	}
+
+	if err := os.Truncate(appLog, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(auditLog); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.updateLag(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if l := losses[appLog]; l == nil || l.Reason != configurer.LossTruncated || l.Bytes != 6 {
+		t.Errorf("expect 6 bytes truncated of %s, got %+v", appLog, l)
+	}
+	if l := losses[auditLog]; l == nil || l.Reason != configurer.LossRemoved || l.Bytes != 8 {
+		t.Errorf("expect 8 bytes removed of %s, got %+v", auditLog, l)
+	}
+	if v := testutil.ToFloat64(lossBytes.WithLabelValues("loss", "app-0", "app", configurer.LossRemoved)); v != 8 {
+		t.Errorf("expect 8 bytes lost by removal, got %v", v)
+	}
+}
+
+func TestRotatedNotLost(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	appLog := filepath.Join(home, "app.log")
+	rotated := appLog + ".1"
+	if err := ioutil.WriteFile(appLog, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeRegistry(t, c, appLog, 4)
+
+	losses := []*configurer.Loss{}
+	c.NotifyLoss(func(loss *configurer.Loss) {
+		losses = append(losses, loss)
+	})
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1", Namespace: "rotate", Pod: "app-0", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{Name: "app", LogFile: appLog},
+		},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated while filebeat is still reading it, the offset stalls.
+	if err := os.Rename(appLog, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(appLog, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeRegistry(t, c, rotated, 4)
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+	if len(losses) != 0 {
+		t.Fatalf("expect no loss while rotated file is in registry, got %+v", losses[0])
+	}
+
+	// Shipped, then removed and cleaned between two updates of lag.
+	writeRegistry(t, c, rotated, 10)
+	if err := c.trackOffsets(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.getRegistryFile(), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+	if len(losses) != 0 {
+		t.Errorf("expect no loss after shipped, got %+v", losses[0])
+	}
+}
+
+func TestTruncatedShippedNotLost(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c := newTestConfigurer(t, home, "- type: log\n  paths: [{{ .containerId }}]\n")
+	appLog := filepath.Join(home, "app.log")
+	if err := ioutil.WriteFile(appLog, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeRegistry(t, c, appLog, 10)
+
+	losses := []*configurer.Loss{}
+	c.NotifyLoss(func(loss *configurer.Loss) {
+		losses = append(losses, loss)
+	})
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1", Namespace: "copytruncate", Pod: "app-0", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{Name: "app", LogFile: appLog},
+		},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copied and truncated after filebeat shipped the whole file.
+	if err := os.Truncate(appLog, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateLag(); err != nil {
+		t.Fatal(err)
+	}
+	if len(losses) != 0 {
+		t.Errorf("expect no loss of shipped file, got %+v", losses[0])
+	}
+}
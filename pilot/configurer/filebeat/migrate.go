@@ -0,0 +1,182 @@
+package filebeat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/fileutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// migrateFunc recovers log configs of an input file written by an old
+// version, which has no log configs in its header.
+type migrateFunc func(f *configurer.InputConfigFile) ([]*configurer.LogConfig, error)
+
+var migrations = map[string]migrateFunc{
+	inputConfigVersionV0_1: migrateV0_1,
+}
+
+// Migrate re-renders input files of old versions with the current template.
+// Files are rewritten in place, so that filebeat keeps harvesting them as the
+// same inputs. Files which can't be migrated are removed by BootstrapCheck.
+func (c *filebeatConfigurer) Migrate(dryRun bool) ([]*configurer.Migration, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.migrate(dryRun)
+}
+
+func (c *filebeatConfigurer) migrate(dryRun bool) ([]*configurer.Migration, error) {
+	inputConfDir := c.getInputsDir()
+	ret := []*configurer.Migration{}
+	files, err := ioutil.ReadDir(inputConfDir)
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		path := filepath.Join(inputConfDir, files[i].Name())
+		f, err := configurer.LoadInputConfigFile(path)
+		if err != nil || f.Version == currentInputConfigVersion {
+			continue
+		}
+
+		m := &configurer.Migration{
+			Path:        path,
+			ContainerID: f.ContainerID,
+			From:        f.Version,
+			To:          currentInputConfigVersion,
+			Action:      configurer.MigrationRerender,
+		}
+		ret = append(ret, m)
+		data, err := c.rerender(f)
+		if err != nil {
+			m.Action = configurer.MigrationRemove
+			m.Error = err.Error()
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		if err := fileutil.WriteFileAtomic(path, data, 0644); err != nil {
+			return ret, fmt.Errorf("error write config file: %v", err)
+		}
+	}
+	return ret, nil
+}
+
+// rerender renders an old version input file with the current template.
+func (c *filebeatConfigurer) rerender(f *configurer.InputConfigFile) ([]byte, error) {
+	ev := f.AddEvent()
+	if migrate, ok := migrations[f.Version]; ok {
+		configs, err := migrate(f)
+		if err != nil {
+			return nil, err
+		}
+		ev.LogConfigs = configs
+	} else if len(f.LogConfigs) == 0 {
+		return nil, fmt.Errorf("unknown version %s", f.Version)
+	}
+
+	content, err := c.render(ev)
+	if err != nil {
+		return nil, fmt.Errorf("error render config file: %v", err)
+	}
+	meta := configurer.NewInputConfigFile(ev, currentInputConfigVersion, []byte(content))
+	if !f.Created.IsZero() {
+		meta.Created = f.Created
+	}
+	return meta.Encode([]byte(content))
+}
+
+// inputV0_1 contains fields of inputs rendered by templates of v0.1, for
+// both the log input and the filestream input.
+type inputV0_1 struct {
+	ID                string                   `yaml:"id"`
+	Paths             []string                 `yaml:"paths"`
+	Fields            map[string]string        `yaml:"fields"`
+	DockerJSON        interface{}              `yaml:"docker-json"`
+	JSONKeysUnderRoot bool                     `yaml:"json.keys_under_root"`
+	Parsers           []map[string]interface{} `yaml:"parsers"`
+}
+
+// inputOptionsV0_1 are the log options of v0.1. The default template didn't
+// render them, but custom templates put them in fields along with tags. v0.1
+// had no output options.
+var inputOptionsV0_1 = map[string]bool{
+	"multiline_pattern": true,
+	"include_lines":     true,
+	"exclude_lines":     true,
+}
+
+// migrateV0_1 parses log configs from inputs of v0.1 files.
+func migrateV0_1(f *configurer.InputConfigFile) ([]*configurer.LogConfig, error) {
+	body, err := configurer.ReadInputBody(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	inputs := []inputV0_1{}
+	if err := yaml.Unmarshal(body, &inputs); err != nil {
+		return nil, fmt.Errorf("error parse inputs: %v", err)
+	}
+
+	ret := []*configurer.LogConfig{}
+	for i, input := range inputs {
+		if len(input.Paths) != 1 {
+			return nil, fmt.Errorf("expect 1 path in input %d, got %d", i, len(input.Paths))
+		}
+		cfg := &configurer.LogConfig{
+			LogFile: input.Paths[0],
+			Format:  configurer.LogFormatPlain,
+			Tags:    map[string]string{},
+			InOpts:  map[string]string{},
+			Stdout:  input.DockerJSON != nil,
+		}
+		if input.JSONKeysUnderRoot {
+			cfg.Format = configurer.LogFormatJSON
+		}
+		for _, parser := range input.Parsers {
+			if _, ok := parser["container"]; ok {
+				cfg.Stdout = true
+			}
+			if _, ok := parser["ndjson"]; ok {
+				cfg.Format = configurer.LogFormatJSON
+			}
+		}
+		// Stdout of docker is always json.
+		if cfg.Stdout {
+			cfg.Format = configurer.LogFormatJSON
+		}
+
+		// Filestream inputs are identified by <container id>-<name>.
+		switch {
+		case strings.HasPrefix(input.ID, f.ContainerID+"-"):
+			cfg.Name = strings.TrimPrefix(input.ID, f.ContainerID+"-")
+		case cfg.Stdout:
+			cfg.Name = "stdout"
+		default:
+			cfg.Name = fmt.Sprintf("legacy_%d", i)
+		}
+
+		for k, v := range input.Fields {
+			// Added by the template.
+			if k == "cluster" {
+				continue
+			}
+			if inputOptionsV0_1[k] {
+				cfg.InOpts[k] = v
+				continue
+			}
+			cfg.Tags[k] = v
+		}
+		ret = append(ret, cfg)
+	}
+	return ret, nil
+}
@@ -0,0 +1,131 @@
+package filebeat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+// Fixtures of v0.1 are rendered by log-pilot v0.1 with its default template,
+// and a custom template which also puts log options in fields.
+func TestMigrateV0_1(t *testing.T) {
+	cont := container.Container{ID: "abc", Name: "app", Namespace: "default", Pod: "app-0"}
+	tags := map[string]string{
+		"kubernetes.pod_name":       "app-0",
+		"kubernetes.namespace_name": "default",
+		"kubernetes.container_name": "app",
+		"node_name":                 "node-1",
+	}
+	fileTags := map[string]string{"filePath": "/log/access.log"}
+	for k, v := range tags {
+		fileTags[k] = v
+	}
+	stdout := &configurer.LogConfig{
+		Name:    "stdout",
+		LogFile: "/var/lib/docker/containers/abc/abc-json.log",
+		Format:  configurer.LogFormatJSON,
+		Tags:    tags,
+		Stdout:  true,
+	}
+
+	tests := []struct {
+		fixture string
+		inOpts  map[string]string
+	}{
+		{"default.yml", nil},
+		{"inopts.yml", map[string]string{"exclude_lines": "^DEBUG"}},
+	}
+	for _, test := range tests {
+		expect := []*configurer.LogConfig{stdout, &configurer.LogConfig{
+			Name:    "legacy_1",
+			LogFile: "/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~empty-dir/log/access.log",
+			Format:  configurer.LogFormatPlain,
+			Tags:    fileTags,
+			InOpts:  test.inOpts,
+		}}
+		content, err := ioutil.ReadFile(filepath.Join("testdata/v0.1", test.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tpl := range []string{"filebeat-6.tpl", "filebeat-7.tpl"} {
+			home, err := ioutil.TempDir("", "filebeat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(home)
+
+			tmpl, err := ioutil.ReadFile(filepath.Join("../../../assets/filebeat", tpl))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newTestConfigurer(t, home, string(tmpl))
+			path := filepath.Join(c.getInputsDir(), configurer.InputFilename(&cont, inputConfigVersionV0_1))
+			if err := ioutil.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			migrations, err := c.Migrate(true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(migrations) != 1 || migrations[0].Action != configurer.MigrationRerender {
+				t.Fatalf("%s %s: unexpected migrations %#v", test.fixture, tpl, migrations)
+			}
+			if data, _ := ioutil.ReadFile(path); string(data) != string(content) {
+				t.Errorf("%s %s: file changed in dry run", test.fixture, tpl)
+			}
+
+			files, err := c.BootstrapCheck()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, ok := files["abc"]
+			if !ok || f.Path != path || f.Version != currentInputConfigVersion {
+				t.Fatalf("%s %s: expect file migrated in place, got %#v", test.fixture, tpl, f)
+			}
+			if !reflect.DeepEqual(f.LogConfigs, expect) {
+				got, _ := json.Marshal(f.LogConfigs)
+				t.Errorf("%s %s: unexpected log configs %s", test.fixture, tpl, got)
+			}
+			rendered, err := c.render(&configurer.ContainerAddEvent{Container: cont, LogConfigs: expect})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := configurer.ReadInputBody(path); string(body) != rendered {
+				t.Errorf("%s %s: expect the file rendered with the current template, got\n%s", test.fixture, tpl, body)
+			}
+
+			// Container updates are written to the migrated file.
+			if got := c.getContainerConfigPath(&cont); got != path {
+				t.Errorf("%s %s: expect path %s, got %s", test.fixture, tpl, path, got)
+			}
+		}
+	}
+}
+
+func TestMigratorDryRun(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	m, err := NewMigrator("../../../assets/filebeat/filebeat-7.tpl", home, DefaultFilebeatVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := m.Migrate(true)
+	if err != nil || len(migrations) != 0 {
+		t.Fatalf("expect no migrations, got %v, %v", migrations, err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "inputs.d")); !os.IsNotExist(err) {
+		t.Errorf("expect inputs dir not created, got %v", err)
+	}
+}
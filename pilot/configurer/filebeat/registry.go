@@ -0,0 +1,371 @@
+package filebeat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registryReader reads file states from filebeat registry. States are keyed
+// by source path.
+type registryReader interface {
+	Read() (map[string]RegistryState, error)
+}
+
+// newRegistryReader detects registry format of the path. Filebeat 6 stores
+// states in a single JSON file, while filebeat 7 and later create a
+// directory which contains a memlog store.
+func newRegistryReader(path string) (registryReader, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &memlogRegistry{dir: filepath.Join(path, "filebeat")}, nil
+	}
+	return &jsonRegistry{path: path}, nil
+}
+
+// jsonRegistry reads the registry of filebeat 6, which is a JSON array of
+// states. Filebeat rewrites the whole file on every flush, the states are
+// cached until the file changes, and decoded one by one to avoid holding the
+// whole array in memory.
+type jsonRegistry struct {
+	path    string
+	modTime time.Time
+	size    int64
+	states  map[string]RegistryState
+}
+
+func (r *jsonRegistry) Read() (map[string]RegistryState, error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if r.states != nil && fi.ModTime().Equal(r.modTime) && fi.Size() == r.size {
+		return r.states, nil
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	statesMap := make(map[string]RegistryState, len(r.states))
+	for decoder.More() {
+		state := RegistryState{}
+		if err := decoder.Decode(&state); err != nil {
+			return nil, err
+		}
+		if _, ok := statesMap[state.Source]; !ok {
+			statesMap[state.Source] = state
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	r.states = statesMap
+	r.modTime = fi.ModTime()
+	r.size = fi.Size()
+	return statesMap, nil
+}
+
+const (
+	memlogActiveFile    = "active.dat"
+	memlogLogFile       = "log.json"
+	memlogCheckpointExt = ".json"
+	memlogCheckpointKey = "_key"
+
+	memlogOpSet    = "set"
+	memlogOpRemove = "remove"
+
+	// Key prefixes of states written by the log input and filestream input.
+	logInputKeyPrefix   = "filebeat::logs::"
+	filestreamKeyPrefix = "filestream::"
+	// Identifier of states keyed by inode and device.
+	memlogNativeIdentity = "native"
+)
+
+// memlogRegistry reads the memlog store of filebeat 7 and later. The store
+// consists of a checkpoint file, whose name is referenced by active.dat, and
+// log.json, which records operations after the checkpoint as pairs of lines:
+//
+//	{"op":"set","id":42}
+//	{"k":"filebeat::logs::native::1234-2049","v":{...}}
+//
+// Filebeat appends to log.json until the next checkpoint, so the reader
+// remembers the offset in log.json and only parses new operations, the
+// checkpoint is loaded again after it changed.
+type memlogRegistry struct {
+	dir string
+
+	// txid is the transaction id of the loaded checkpoint.
+	txid uint64
+	// states are file states keyed by memlog keys.
+	states map[string]RegistryState
+	// log is the log.json file read last time, and offset is the position
+	// after the last complete operation.
+	log    os.FileInfo
+	offset int64
+}
+
+// memlogState is the value of a state in memlog store. Log input states have
+// source and offset at top level, filestream states keep them in meta and
+// cursor.
+type memlogState struct {
+	Source      string    `json:"source"`
+	Offset      int64     `json:"offset"`
+	TTL         int64     `json:"ttl"`
+	Type        string    `json:"type"`
+	FileStateOS FileInode `json:"FileStateOS"`
+	Cursor      *struct {
+		Offset int64 `json:"offset"`
+	} `json:"cursor"`
+	Meta *struct {
+		Source string `json:"source"`
+	} `json:"meta"`
+}
+
+func (r *memlogRegistry) Read() (map[string]RegistryState, error) {
+	txid, err := r.activeTxid()
+	if err != nil {
+		return nil, err
+	}
+	if r.states == nil || txid != r.txid {
+		if err := r.readCheckpoint(txid); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.readLog(); err != nil {
+		return nil, err
+	}
+
+	statesMap := make(map[string]RegistryState, len(r.states))
+	for _, state := range r.states {
+		if _, exist := statesMap[state.Source]; !exist {
+			statesMap[state.Source] = state
+		}
+	}
+	return statesMap, nil
+}
+
+// activeTxid returns transaction id of the active checkpoint, or 0 if there
+// is no checkpoint yet.
+func (r *memlogRegistry) activeTxid() (uint64, error) {
+	active, err := ioutil.ReadFile(filepath.Join(r.dir, memlogActiveFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	// active.dat contains the absolute path of checkpoint file in filebeat
+	// container, which may be mounted at another path here.
+	name := filepath.Base(strings.TrimSpace(string(active)))
+	txid, err := strconv.ParseUint(strings.TrimSuffix(name, memlogCheckpointExt), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint file %s", name)
+	}
+	return txid, nil
+}
+
+// readCheckpoint loads states in the checkpoint, and resets log.json to be
+// read from the beginning.
+func (r *memlogRegistry) readCheckpoint(txid uint64) error {
+	states := make(map[string]RegistryState)
+	if txid > 0 {
+		name := strconv.FormatUint(txid, 10) + memlogCheckpointExt
+		f, err := os.Open(filepath.Join(r.dir, name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		decoder := json.NewDecoder(bufio.NewReader(f))
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("error decode checkpoint %s: %v", name, err)
+		}
+		for decoder.More() {
+			var item map[string]json.RawMessage
+			if err := decoder.Decode(&item); err != nil {
+				return fmt.Errorf("error decode checkpoint %s: %v", name, err)
+			}
+			var key string
+			if err := json.Unmarshal(item[memlogCheckpointKey], &key); err != nil {
+				return fmt.Errorf("error decode checkpoint key: %v", err)
+			}
+			delete(item, memlogCheckpointKey)
+			value, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := r.set(states, key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.txid = txid
+	r.states = states
+	r.log = nil
+	r.offset = 0
+	return nil
+}
+
+// readLog applies operations in log.json newer than the checkpoint, starting
+// from the offset of last read. log.json is truncated after a checkpoint, in
+// which case it is read from the beginning.
+func (r *memlogRegistry) readLog() error {
+	f, err := os.Open(filepath.Join(r.dir, memlogLogFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if r.log == nil || !os.SameFile(r.log, fi) || fi.Size() < r.offset {
+		r.offset = 0
+	}
+	r.log = fi
+	if fi.Size() == r.offset {
+		return nil
+	}
+	if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var (
+		op struct {
+			Op string `json:"op"`
+			ID uint64 `json:"id"`
+		}
+		entry struct {
+			K string          `json:"k"`
+			V json.RawMessage `json:"v"`
+		}
+	)
+	reader := bufio.NewReaderSize(f, 64*1024)
+	for {
+		// The last operation may be partially written, it is read again next
+		// time since offset only moves after a complete operation.
+		opLine, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		entryLine, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+
+		op.Op, op.ID = "", 0
+		entry.K, entry.V = "", nil
+		if json.Unmarshal(opLine, &op) != nil || json.Unmarshal(entryLine, &entry) != nil {
+			// Filebeat also stops reading at an invalid operation.
+			break
+		}
+		r.offset += int64(len(opLine) + len(entryLine))
+
+		if op.ID <= r.txid {
+			continue
+		}
+		switch op.Op {
+		case memlogOpSet:
+			if err := r.set(r.states, entry.K, entry.V); err != nil {
+				return err
+			}
+		case memlogOpRemove:
+			delete(r.states, entry.K)
+		}
+	}
+	return nil
+}
+
+func (r *memlogRegistry) set(states map[string]RegistryState, key string, raw json.RawMessage) error {
+	state, ok, err := decodeMemlogState(key, raw)
+	if err != nil {
+		return fmt.Errorf("error decode state %s: %v", key, err)
+	}
+	if ok {
+		states[key] = state
+	} else {
+		delete(states, key)
+	}
+	return nil
+}
+
+// decodeMemlogState converts a memlog entry to RegistryState, it returns
+// false if the entry is not a file state.
+func decodeMemlogState(key string, raw json.RawMessage) (RegistryState, bool, error) {
+	var typ string
+	switch {
+	case strings.HasPrefix(key, logInputKeyPrefix):
+		typ = "log"
+	case strings.HasPrefix(key, filestreamKeyPrefix):
+		typ = "filestream"
+	default:
+		return RegistryState{}, false, nil
+	}
+
+	s := memlogState{}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return RegistryState{}, false, err
+	}
+	state := RegistryState{
+		Source:      s.Source,
+		Offset:      s.Offset,
+		TTL:         time.Duration(s.TTL),
+		Type:        typ,
+		FileStateOS: s.FileStateOS,
+	}
+	if s.Meta != nil && s.Meta.Source != "" {
+		state.Source = s.Meta.Source
+	}
+	if s.Cursor != nil {
+		state.Offset = s.Cursor.Offset
+	}
+	if state.FileStateOS == (FileInode{}) {
+		state.FileStateOS = inodeFromKey(key)
+	}
+	if state.Source == "" {
+		return RegistryState{}, false, nil
+	}
+	return state, true, nil
+}
+
+// inodeFromKey parses inode and device from keys like
+// filestream::<id>::native::<inode>-<device>.
+func inodeFromKey(key string) FileInode {
+	idx := strings.LastIndex(key, memlogNativeIdentity+"::")
+	if idx < 0 {
+		return FileInode{}
+	}
+	parts := strings.SplitN(key[idx+len(memlogNativeIdentity)+2:], "-", 2)
+	if len(parts) != 2 {
+		return FileInode{}
+	}
+	inode, err1 := strconv.ParseUint(parts[0], 10, 64)
+	device, err2 := strconv.ParseUint(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return FileInode{}
+	}
+	return FileInode{Inode: inode, Device: device}
+}
@@ -0,0 +1,143 @@
+package filebeat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRegistry(t *testing.T) {
+	expect := map[string]RegistryState{
+		"/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~empty-dir/log/app.log": RegistryState{
+			Offset:      1024,
+			FileStateOS: FileInode{Inode: 1001, Device: 2049},
+		},
+		"/var/lib/docker/containers/abc/abc-json.log": RegistryState{
+			Offset:      2048,
+			FileStateOS: FileInode{Inode: 1002, Device: 2049},
+		},
+	}
+
+	cases := []struct {
+		path   string
+		reader registryReader
+	}{
+		{"testdata/v6/data/registry", &jsonRegistry{}},
+		{"testdata/v7/data/registry", &memlogRegistry{}},
+	}
+	for _, cas := range cases {
+		reader, err := newRegistryReader(cas.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch reader.(type) {
+		case *jsonRegistry:
+			if _, ok := cas.reader.(*jsonRegistry); !ok {
+				t.Errorf("%s: expect memlog registry", cas.path)
+			}
+		case *memlogRegistry:
+			if _, ok := cas.reader.(*memlogRegistry); !ok {
+				t.Errorf("%s: expect json registry", cas.path)
+			}
+		}
+
+		states, err := reader.Read()
+		if err != nil {
+			t.Fatalf("%s: %v", cas.path, err)
+		}
+		if len(states) != len(expect) {
+			t.Errorf("%s: expect %d states, got %#v", cas.path, len(expect), states)
+		}
+		for source, e := range expect {
+			s, ok := states[source]
+			if !ok {
+				t.Errorf("%s: state of %s not found", cas.path, source)
+				continue
+			}
+			if s.Source != source || s.Offset != e.Offset || s.FileStateOS != e.FileStateOS {
+				t.Errorf("%s: expect %#v, got %#v", cas.path, e, s)
+			}
+		}
+	}
+}
+
+func TestReadMemlogWithoutCheckpoint(t *testing.T) {
+	r := &memlogRegistry{dir: "testdata/not-exist"}
+	states, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 0 {
+		t.Errorf("expect no state, got %#v", states)
+	}
+}
+
+func TestReadMemlogIncremental(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := "testdata/v7/data/registry/filebeat"
+	for _, name := range []string{"active.dat", "3.json", "log.json"} {
+		data, err := ioutil.ReadFile(filepath.Join(src, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendLog := func(s string) {
+		f, err := os.OpenFile(filepath.Join(dir, "log.json"), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectOffset := func(r *memlogRegistry, source string, offset int64) {
+		states, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, ok := states[source]; !ok || s.Offset != offset {
+			t.Errorf("expect offset %d of %s, got %#v", offset, source, s)
+		}
+	}
+
+	app := "/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~empty-dir/log/app.log"
+	r := &memlogRegistry{dir: dir}
+	expectOffset(r, app, 1024)
+
+	// Complete the partial operation, and append a new one.
+	appendLog(`49","v":{"source":"/var/log/new.log","offset":1,"FileStateOS":{"inode":1004,"device":2049}}}` + "\n")
+	appendLog(`{"op":"set","id":8}` + "\n" + `{"k":"filebeat::logs::native::1001-2049","v":{"source":"` + app + `","offset":4096}}` + "\n")
+	offset := r.offset
+	expectOffset(r, "/var/log/new.log", 1)
+	expectOffset(r, app, 4096)
+	if r.offset <= offset {
+		t.Errorf("expect log.json read incrementally")
+	}
+
+	// A new checkpoint, log.json is truncated.
+	checkpoint := `[{"_key":"filebeat::logs::native::1001-2049","source":"` + app + `","offset":8192}]`
+	if err := ioutil.WriteFile(filepath.Join(dir, "9.json"), []byte(checkpoint), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "active.dat"), []byte("/usr/share/filebeat/data/registry/filebeat/9.json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "log.json"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectOffset(r, app, 8192)
+	states, _ := r.Read()
+	if len(states) != 1 {
+		t.Errorf("expect states reloaded from checkpoint, got %#v", states)
+	}
+}
@@ -0,0 +1,261 @@
+package filebeat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"gopkg.in/fsnotify/fsnotify.v1"
+)
+
+// templateDebounce is the delay to reload after the template changed, a
+// ConfigMap update generates several events.
+const templateDebounce = time.Second
+
+// sampleEvents are rendered to validate templates, they cover stdout, plain
+// and json log files.
+var sampleEvents = []*configurer.ContainerAddEvent{
+	&configurer.ContainerAddEvent{
+		Container: container.Container{
+			ID:        "0123456789abcdef",
+			Name:      "app",
+			Namespace: "default",
+			Pod:       "app-0",
+			PodID:     "00000000-0000-0000-0000-000000000000",
+		},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{
+				Name:    "stdout",
+				LogFile: "/var/lib/docker/containers/0123456789abcdef/0123456789abcdef-json.log",
+				Format:  configurer.LogFormatJSON,
+				Tags:    map[string]string{"kubernetes.pod_name": "app-0"},
+				Stdout:  true,
+			},
+			&configurer.LogConfig{
+				Name:    "access",
+				LogFile: "/var/lib/kubelet/pods/00000000-0000-0000-0000-000000000000/volumes/kubernetes.io~empty-dir/log/access.log",
+				Format:  configurer.LogFormatPlain,
+				Tags:    map[string]string{"filePath": "/log/access.log"},
+				InOpts:  map[string]string{"multiline_pattern": "^\\d"},
+			},
+			&configurer.LogConfig{
+				Name:    "json",
+				LogFile: "/var/lib/kubelet/pods/00000000-0000-0000-0000-000000000000/volumes/kubernetes.io~empty-dir/log/*.json",
+				Format:  configurer.LogFormatJSON,
+			},
+		},
+	},
+}
+
+// loadTemplate selects the template for the filebeat version, and validates
+// it before use.
+func (c *filebeatConfigurer) loadTemplate() (*template.Template, string, error) {
+	file, err := selectTemplate(c.templatePath, c.version)
+	if err != nil {
+		return nil, "", fmt.Errorf("error select log template: %v", err)
+	}
+	t, err := parseTemplate(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("error parse log template: %v", err)
+	}
+	if err := c.validateTemplate(t); err != nil {
+		return nil, "", fmt.Errorf("invalid log template %s: %v", file, err)
+	}
+	return t, file, nil
+}
+
+// validateTemplate renders sample events, and checks the results can be
+// loaded by filebeat as a list of inputs.
+func (c *filebeatConfigurer) validateTemplate(t *template.Template) error {
+	for _, ev := range sampleEvents {
+		content, err := renderTemplate(t, ev, c.version)
+		if err != nil {
+			return err
+		}
+		n, err := checkInputs(content)
+		if err != nil {
+			return err
+		}
+		if n != len(ev.LogConfigs) {
+			return fmt.Errorf("expect %d inputs rendered, got %d", len(ev.LogConfigs), n)
+		}
+	}
+	return nil
+}
+
+// Profile templates are put in the same directory as the template, named as
+// <profile>.profile.tpl, or <profile>.profile-<version>.tpl for filebeat of
+// the version or newer. Logs are rendered by the template of their profile,
+// or format if there is a template named by the format, e.g. json.profile.tpl.
+const (
+	profileSep    = ".profile"
+	profileSuffix = ".tpl"
+)
+
+// templateDir returns the directory of the template and profiles.
+func (c *filebeatConfigurer) templateDir() (string, error) {
+	fi, err := os.Stat(c.templatePath)
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return c.templatePath, nil
+	}
+	return filepath.Dir(c.templatePath), nil
+}
+
+// loadProfiles loads and validates profile templates.
+func (c *filebeatConfigurer) loadProfiles() (map[string]*template.Template, error) {
+	dir, err := c.templateDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		file    string
+		version filebeatVersion
+	}
+	selected := map[string]candidate{}
+	for _, f := range files {
+		name := f.Name()
+		i := strings.Index(name, profileSep)
+		if i <= 0 || !strings.HasSuffix(name, profileSuffix) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		profile := name[:i]
+		v := filebeatVersion{}
+		if ver := strings.TrimSuffix(name[i+len(profileSep):], profileSuffix); ver != "" {
+			if !strings.HasPrefix(ver, "-") {
+				continue
+			}
+			if v, err = parseFilebeatVersion(ver[1:]); err != nil {
+				c.logger.Warnf("ignore profile template %s: %v", name, err)
+				continue
+			}
+		}
+		if c.version.less(v) {
+			continue
+		}
+		if old, ok := selected[profile]; ok && !old.version.less(v) {
+			continue
+		}
+		selected[profile] = candidate{file: filepath.Join(dir, name), version: v}
+	}
+
+	profiles := make(map[string]*template.Template, len(selected))
+	for profile, cand := range selected {
+		t, err := parseTemplate(cand.file)
+		if err != nil {
+			return nil, fmt.Errorf("error parse profile template %s: %v", cand.file, err)
+		}
+		if err := c.validateTemplate(t); err != nil {
+			return nil, fmt.Errorf("invalid profile template %s: %v", cand.file, err)
+		}
+		profiles[profile] = t
+	}
+	return profiles, nil
+}
+
+// profileOf returns the profile used to render the log, it's empty if the
+// log is rendered by the default template.
+func (c *filebeatConfigurer) profileOf(cfg *configurer.LogConfig) string {
+	if _, ok := c.profiles[cfg.Profile]; ok && cfg.Profile != "" {
+		return cfg.Profile
+	}
+	if _, ok := c.profiles[string(cfg.Format)]; ok {
+		return string(cfg.Format)
+	}
+	return ""
+}
+
+// watchTemplate reloads the template when it changed. The template may be
+// mounted from a ConfigMap, whose files are updated by replacing the ..data
+// symlink.
+func (c *filebeatConfigurer) watchTemplate() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	dir, err := c.templateDir()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(dir); err != nil {
+		return err
+	}
+	c.logger.Infof("watching template changes in %s", dir)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-c.closeCh:
+			return nil
+		case ev := <-w.Events:
+			if !c.isTemplateEvent(ev) {
+				continue
+			}
+			c.logger.Debugf("template event: %v", ev)
+			if debounce == nil {
+				debounce = time.After(templateDebounce)
+			}
+		case err := <-w.Errors:
+			c.logger.Warnf("template watcher error: %v", err)
+		case <-debounce:
+			debounce = nil
+			if err := c.reloadTemplate(); err != nil {
+				c.logger.Errorf("error reload template, keep using the old one: %v", err)
+			}
+		}
+	}
+}
+
+func (c *filebeatConfigurer) isTemplateEvent(ev fsnotify.Event) bool {
+	base := filepath.Base(ev.Name)
+	if base == "..data" {
+		return ev.Op&fsnotify.Create == fsnotify.Create
+	}
+	if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+		return false
+	}
+	match, _ := filepath.Match("*.tpl", base)
+	return match || ev.Name == c.templatePath
+}
+
+// reloadTemplate loads the template again, and re-renders input files of all
+// the running containers. Files with unchanged content are not rewritten.
+func (c *filebeatConfigurer) reloadTemplate() error {
+	t, file, err := c.loadTemplate()
+	if err != nil {
+		return err
+	}
+	profiles, err := c.loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.tmpl = t
+	c.profiles = profiles
+	c.logger.Infof("Reloaded template %s, re-render inputs of %d containers", file, len(c.containers))
+	for _, ev := range c.containers {
+		if err := c.writeInput(ev); err != nil {
+			c.logger.Errorw("Error update config", append(ev.Container.LogFields(), "error", err)...)
+		}
+	}
+	return nil
+}
@@ -1,9 +1,105 @@
 package filebeat
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
 const (
 	inputConfigVersionV0_1 = "v0.1"
+	// v0.2 embeds log configs in the metadata header.
+	inputConfigVersionV0_2 = "v0.2"
 )
 
 var (
-	currentInputConfigVersion = inputConfigVersionV0_1
+	currentInputConfigVersion = inputConfigVersionV0_2
+)
+
+// DefaultFilebeatVersion is the filebeat version shipped with log-pilot.
+const DefaultFilebeatVersion = "6.5"
+
+// filebeatVersion is the version of filebeat driven by the configurer.
+type filebeatVersion struct {
+	major, minor int
+}
+
+// parseFilebeatVersion parses versions like 7, 7.17 and 7.17.3, patch
+// version is ignored.
+func parseFilebeatVersion(s string) (filebeatVersion, error) {
+	v := filebeatVersion{}
+	items := strings.SplitN(strings.TrimPrefix(s, "v"), ".", 3)
+	var err error
+	if v.major, err = strconv.Atoi(items[0]); err != nil {
+		return v, fmt.Errorf("invalid filebeat version %q", s)
+	}
+	if len(items) > 1 {
+		if v.minor, err = strconv.Atoi(items[1]); err != nil {
+			return v, fmt.Errorf("invalid filebeat version %q", s)
+		}
+	}
+	return v, nil
+}
+
+func (v filebeatVersion) less(o filebeatVersion) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	return v.minor < o.minor
+}
+
+func (v filebeatVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+const (
+	templateSetPrefix = "filebeat-"
+	templateSetSuffix = ".tpl"
 )
+
+// selectTemplate returns the template file for the filebeat version. If path
+// is a directory, it contains template sets named as filebeat-<version>.tpl,
+// and the newest one which is not newer than the filebeat is chosen. For
+// example, filebeat-7.tpl is used for filebeat 7.17 and 8.11 if there is no
+// filebeat-8.tpl.
+func selectTemplate(path string, v filebeatVersion) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return path, nil
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var (
+		selected    string
+		selectedVer filebeatVersion
+	)
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasPrefix(name, templateSetPrefix) || !strings.HasSuffix(name, templateSetSuffix) {
+			continue
+		}
+		tv, err := parseFilebeatVersion(strings.TrimSuffix(strings.TrimPrefix(name, templateSetPrefix), templateSetSuffix))
+		if err != nil {
+			continue
+		}
+		if v.less(tv) {
+			continue
+		}
+		if selected == "" || selectedVer.less(tv) {
+			selected, selectedVer = name, tv
+		}
+	}
+	if selected == "" {
+		return "", fmt.Errorf("no template for filebeat %v in %s", v, path)
+	}
+	return filepath.Join(path, selected), nil
+}
@@ -0,0 +1,108 @@
+// Package funcs provides functions for templates of configurers, which are
+// similar to the ones in sprig.
+package funcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FuncMap returns the common functions, merged with functions in extra.
+func FuncMap(extra ...template.FuncMap) template.FuncMap {
+	m := template.FuncMap{
+		"default":      defaultValue,
+		"join":         join,
+		"regexReplace": regexReplace,
+		"toYaml":       toYaml,
+		"env":          os.Getenv,
+		"indent":       indent,
+		"quote":        quote,
+	}
+	for _, e := range extra {
+		for k, v := range e {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// defaultValue returns def if v is empty, e.g. {{ .Tags.app | default "unknown" }}.
+func defaultValue(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || isEmpty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
+
+// join concatenates elements of list with sep.
+func join(sep string, list interface{}) (string, error) {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: expect a list, got %T", list)
+	}
+	items := make([]string, rv.Len())
+	for i := range items {
+		items[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(items, sep), nil
+}
+
+// regexReplace replaces matches of regex in s with repl, s is the last
+// argument to be used in pipelines, e.g. {{ .Name | regexReplace "[^a-z]" "_" }}.
+func regexReplace(regex, repl, s string) (string, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// toYaml marshals v as a YAML document, the result should be indented to
+// be placed in a block, e.g. {{ toYaml .Tags | indent 6 }}.
+func toYaml(v interface{}) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// indent adds n spaces before each line of s.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// quote quotes v as a double-quoted YAML scalar, which never spans multiple
+// lines and can be placed at any indentation.
+func quote(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fmt.Sprint(v)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,48 @@
+package funcs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"text/template"
+)
+
+func TestFuncMap(t *testing.T) {
+	os.Setenv("FUNCS_TEST_ENV", "value")
+	defer os.Unsetenv("FUNCS_TEST_ENV")
+
+	data := map[string]interface{}{
+		"empty": "",
+		"name":  "my.app-1",
+		"list":  []string{"a", "b"},
+		"tags":  map[string]string{"b": "2", "a": "1"},
+		"quote": "say \"hi\"\n",
+	}
+	cases := []struct {
+		tpl    string
+		expect string
+	}{
+		{`{{ .empty | default "none" }}`, "none"},
+		{`{{ .name | default "none" }}`, "my.app-1"},
+		{`{{ .missing | default "none" }}`, "none"},
+		{`{{ join "," .list }}`, "a,b"},
+		{`{{ .name | regexReplace "[^a-z0-9]" "_" }}`, "my_app_1"},
+		{`{{ toYaml .tags }}`, "a: \"1\"\nb: \"2\""},
+		{`{{ toYaml .tags | indent 2 }}`, "  a: \"1\"\n  b: \"2\""},
+		{`{{ env "FUNCS_TEST_ENV" }}`, "value"},
+		{`{{ quote .quote }}`, `"say \"hi\"\n"`},
+	}
+	for _, cas := range cases {
+		tmpl, err := template.New("").Funcs(FuncMap()).Parse(cas.tpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Fatalf("%s: %v", cas.tpl, err)
+		}
+		if buf.String() != cas.expect {
+			t.Errorf("%s: expect %q, got %q", cas.tpl, cas.expect, buf.String())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package configurer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+// GCPolicy controls when input files of destroyed containers are removed.
+//
+// An input file is removed once its logs are drained, which means the
+// collector has read every log file up to its size, or its checkpoints stop
+// changing if sizes are unknown, but not earlier than MinLinger after the
+// container was destroyed. If logs are not drained in MaxDrain,
+// the input file is removed by force.
+type GCPolicy struct {
+	// ScanInterval is the interval between two scans.
+	ScanInterval time.Duration
+	// MinLinger is the minimum time to keep input files after the
+	// container is destroyed.
+	MinLinger time.Duration
+	// MaxDrain is the maximum time to wait for logs to be drained after
+	// the container is destroyed.
+	MaxDrain time.Duration
+}
+
+// DefaultGCPolicy returns the default GC policy.
+func DefaultGCPolicy() GCPolicy {
+	return GCPolicy{
+		ScanInterval: 60 * time.Second,
+		MinLinger:    60 * time.Second,
+		MaxDrain:     24 * time.Hour,
+	}
+}
+
+// Validate checks the policy.
+func (p GCPolicy) Validate() error {
+	if p.ScanInterval <= 0 {
+		return fmt.Errorf("gc scan interval must be positive")
+	}
+	if p.MinLinger < 0 || p.MaxDrain < p.MinLinger {
+		return fmt.Errorf("gc max drain time must not be less than min linger time")
+	}
+	return nil
+}
+
+// RunScan calls scan every interval until stopCh is closed, it's the watch
+// loop of configurers which poll states of the collector.
+func RunScan(name string, interval time.Duration, stopCh <-chan bool, logger log.Logger, scan func() error) {
+	logger.Infof("%s watcher start", name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			logger.Infof("%s watcher stop", name)
+			return
+		case <-ticker.C:
+			logger.Infof("%s watcher scan", name)
+
+			startTs := time.Now()
+			err := scan()
+			logger.Debugf("cost %v to complete scan", time.Since(startTs))
+			if err != nil {
+				logger.Errorf("%s watcher scan error: %v", name, err)
+			}
+		}
+	}
+}
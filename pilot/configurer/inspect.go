@@ -0,0 +1,87 @@
+package configurer
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Inspector is implemented by configurers which report states of the
+// containers they track, it's used to debug missing logs.
+type Inspector interface {
+	Inspect() ([]*ContainerState, error)
+}
+
+// ContainerState is the state of a container tracked by a configurer.
+type ContainerState struct {
+	Configurer  string `json:"configurer"`
+	ContainerID string `json:"containerID"`
+	Namespace   string `json:"namespace"`
+	Pod         string `json:"pod"`
+	PodID       string `json:"podUID,omitempty"`
+	Container   string `json:"container"`
+	// LogConfigs are parsed log configs, LogFile of which is the host path.
+	LogConfigs []*LogConfig `json:"logConfigs"`
+	// InputFile is the path of the rendered input file.
+	InputFile string `json:"inputFile"`
+	// Destroyed is when the container was destroyed, it's nil for running
+	// containers. The input file is removed after logs are drained.
+	Destroyed *time.Time `json:"destroyed,omitempty"`
+	// Files are log files matching LogConfigs, with their progress of
+	// shipping.
+	Files []*FileState `json:"files,omitempty"`
+	// Error explains why the state is incomplete, e.g. the configurer does
+	// not support inspection.
+	Error string `json:"error,omitempty"`
+}
+
+// FileState is the progress of shipping a log file.
+type FileState struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Offset is the position read by the shipper, it's -1 if the file is
+	// not harvested yet.
+	Offset int64 `json:"offset"`
+}
+
+// InspectHandler serves states of containers tracked by the configurer as
+// JSON. Containers can be filtered by query parameters id, which is a prefix
+// of container ID, namespace and pod.
+func InspectHandler(c Configurer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inspector, ok := c.(Inspector)
+		if !ok {
+			http.Error(w, c.Name()+" does not support inspection", http.StatusNotImplemented)
+			return
+		}
+		states, err := inspector.Inspect()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		ret := []*ContainerState{}
+		for _, s := range states {
+			if id := query.Get("id"); id != "" && !strings.HasPrefix(s.ContainerID, id) {
+				continue
+			}
+			if ns := query.Get("namespace"); ns != "" && s.Namespace != ns {
+				continue
+			}
+			if pod := query.Get("pod"); pod != "" && s.Pod != pod {
+				continue
+			}
+			ret = append(ret, s)
+		}
+
+		data, err := json.MarshalIndent(ret, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
@@ -0,0 +1,206 @@
+package configurer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/fileutil"
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+// inputMetaPrefix starts the metadata header, which is the first line of
+// input files. It's a comment for YAML configurations.
+const inputMetaPrefix = "# log-pilot: "
+
+// NewInputConfigFile returns metadata of the input file rendered for the
+// container, body is the rendered content.
+func NewInputConfigFile(ev *ContainerAddEvent, version string, body []byte) *InputConfigFile {
+	f := &InputConfigFile{
+		Namespace:   ev.Container.Namespace,
+		Pod:         ev.Container.Pod,
+		PodID:       ev.Container.PodID,
+		Container:   ev.Container.Name,
+		ContainerID: ev.Container.ID,
+		Version:     version,
+		Hash:        fileutil.Hash(body),
+		Created:     time.Now().UTC().Truncate(time.Second),
+	}
+	for _, cfg := range ev.LogConfigs {
+		f.LogFiles = append(f.LogFiles, cfg.LogFile)
+	}
+	f.LogConfigs = ev.LogConfigs
+	return f
+}
+
+// AddEvent returns the add event which the file is rendered for.
+func (f *InputConfigFile) AddEvent() *ContainerAddEvent {
+	return &ContainerAddEvent{
+		Container: container.Container{
+			ID:        f.ContainerID,
+			Name:      f.Container,
+			Namespace: f.Namespace,
+			Pod:       f.Pod,
+			PodID:     f.PodID,
+		},
+		LogConfigs: f.LogConfigs,
+	}
+}
+
+// ReadInputBody returns content of the input file without the header.
+func ReadInputBody(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(inputMetaPrefix)) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return data[i+1:], nil
+		}
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Encode prepends the metadata header to body.
+func (f *InputConfigFile) Encode(body []byte) ([]byte, error) {
+	header, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(inputMetaPrefix)
+	buf.Write(header)
+	buf.WriteByte('\n')
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// ReadInputConfigFile reads the metadata header of an input file. It returns
+// nil if the file has no header, which is written by old versions.
+func ReadInputConfigFile(path string) (*InputConfigFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if !strings.HasPrefix(line, inputMetaPrefix) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("incomplete metadata header")
+	}
+
+	f := &InputConfigFile{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, inputMetaPrefix)), f); err != nil {
+		return nil, fmt.Errorf("invalid metadata header: %v", err)
+	}
+	if f.ContainerID == "" || f.Version == "" {
+		return nil, fmt.Errorf("invalid metadata header: container id and version are required")
+	}
+	f.Path = path
+	return f, nil
+}
+
+// LoadInputConfigFile reads metadata of an input file from its header. Files
+// written by old versions have no header, the metadata is parsed from the
+// filename then, and Hash is left empty.
+func LoadInputConfigFile(path string) (*InputConfigFile, error) {
+	f, err := ReadInputConfigFile(path)
+	if err != nil || f != nil {
+		return f, err
+	}
+	f, err = ParseInputFilename(filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	f.Path = path
+	return f, nil
+}
+
+// LoadInputDir loads input files in dir, keyed by container ID. Files which
+// can't be loaded, or of versions other than version, are removed.
+func LoadInputDir(dir, version string, logger log.Logger) (map[string]*InputConfigFile, error) {
+	return loadInputDir(dir, version, false, logger)
+}
+
+// LoadSharedInputDir is like LoadInputDir, but dir may contain files of
+// users, e.g. the config directory of the collector. Only files named by
+// InputFilename are loaded or removed.
+func LoadSharedInputDir(dir, version string, logger log.Logger) (map[string]*InputConfigFile, error) {
+	return loadInputDir(dir, version, true, logger)
+}
+
+func loadInputDir(dir, version string, shared bool, logger log.Logger) (map[string]*InputConfigFile, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	toRemove := []string{}
+	ret := make(map[string]*InputConfigFile)
+	for i := range files {
+		base := files[i].Name()
+		if files[i].IsDir() {
+			continue
+		}
+		if _, err := ParseInputFilename(base); err != nil && shared {
+			continue
+		}
+		inputConfig, err := LoadInputConfigFile(filepath.Join(dir, base))
+		if err != nil {
+			logger.Warnf("unable to load input config %s: %v", base, err)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		if inputConfig.Version != version {
+			logger.Infof("remove old version: %s", base)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		ret[inputConfig.ContainerID] = inputConfig
+	}
+
+	for _, base := range toRemove {
+		if err := os.Remove(filepath.Join(dir, base)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// InputFilename returns the filename of the container's input file:
+// <namespace>_<pod>_<container_name>_<container_id>_<version>.yml
+func InputFilename(con *container.Container, version string) string {
+	return strings.Join([]string{con.Namespace, con.Pod, con.Name, con.ID, version}, "_") + ".yml"
+}
+
+// ParseInputFilename parses metadata from filename generated by
+// InputFilename.
+func ParseInputFilename(base string) (*InputConfigFile, error) {
+	if !strings.HasSuffix(base, ".yml") {
+		return nil, fmt.Errorf("filename does not end with .yml")
+	}
+	name := base[:len(base)-4]
+	items := strings.Split(name, "_")
+	if len(items) != 5 {
+		return nil, fmt.Errorf("invalid filename pattern: %v", name)
+	}
+
+	return &InputConfigFile{
+		Namespace:   items[0],
+		Pod:         items[1],
+		Container:   items[2],
+		ContainerID: items[3],
+		Version:     items[4],
+	}, nil
+}
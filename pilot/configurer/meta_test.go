@@ -0,0 +1,148 @@
+package configurer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+func TestLoadInputConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ev := &ContainerAddEvent{
+		Container: container.Container{
+			ID:        "abc",
+			Name:      "app",
+			Namespace: "default",
+			Pod:       "app-0",
+			PodID:     "uid-1",
+		},
+		LogConfigs: []*LogConfig{
+			&LogConfig{LogFile: "/var/log/app/*.log"},
+		},
+	}
+	body := []byte("- type: log\n")
+	expect := NewInputConfigFile(ev, "v0.1", body)
+	data, err := expect.Encode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata is read from header, whatever the filename is.
+	expect.Path = filepath.Join(dir, "renamed.yml")
+	if err := ioutil.WriteFile(expect.Path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadInputConfigFile(expect.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expect %#v, got %#v", expect, got)
+	}
+
+	// Files written by old versions.
+	legacy := filepath.Join(dir, InputFilename(&ev.Container, "v0.1"))
+	if err := ioutil.WriteFile(legacy, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = LoadInputConfigFile(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ContainerID != "abc" || got.Pod != "app-0" || got.Version != "v0.1" || got.Hash != "" || got.Path != legacy {
+		t.Errorf("unexpected metadata parsed from filename: %#v", got)
+	}
+
+	invalid := filepath.Join(dir, "invalid.yml")
+	if err := ioutil.WriteFile(invalid, []byte(inputMetaPrefix+"{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadInputConfigFile(invalid); err == nil {
+		t.Errorf("expect error for header without container id")
+	}
+}
+
+func TestLoadInputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	con := &container.Container{ID: "abc", Name: "app", Namespace: "default", Pod: "app-0"}
+	files := map[string]string{
+		InputFilename(con, "v0.2"): "",
+		InputFilename(&container.Container{ID: "def", Name: "app", Namespace: "default", Pod: "app-1"}, "v0.1"): "",
+		"unknown.yml": "",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := LoadInputDir(dir, "v0.2", log.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["abc"] == nil || got["abc"].Path != filepath.Join(dir, InputFilename(con, "v0.2")) {
+		t.Errorf("unexpected input files: %#v", got)
+	}
+	left, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 {
+		t.Errorf("expect unknown and old version files removed, got %d files", len(left))
+	}
+}
+
+func TestLoadSharedInputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	con := &container.Container{ID: "abc", Name: "app", Namespace: "default", Pod: "app-0"}
+	old := InputFilename(&container.Container{ID: "def", Name: "app", Namespace: "default", Pod: "app-1"}, "v0.1")
+	files := map[string]string{
+		InputFilename(con, "v0.2"): "",
+		old:                        "",
+		"sinks.yml":                "sinks: {}\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "transforms"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadSharedInputDir(dir, "v0.2", log.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["abc"] == nil {
+		t.Errorf("unexpected input files: %#v", got)
+	}
+	for _, name := range []string{InputFilename(con, "v0.2"), "sinks.yml", "transforms"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expect %s kept, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, old)); !os.IsNotExist(err) {
+		t.Errorf("expect old version file removed, got %v", err)
+	}
+}
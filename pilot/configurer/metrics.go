@@ -0,0 +1,41 @@
+package configurer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of configurers, labeled by configurer name.
+var (
+	InputsWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_inputs_written_total",
+		Help: "Number of input files written.",
+	}, []string{"configurer"})
+	InputsRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_inputs_removed_total",
+		Help: "Number of input files removed.",
+	}, []string{"configurer"})
+	RenderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_render_errors_total",
+		Help: "Number of input configs failed to render or rejected.",
+	}, []string{"configurer"})
+
+	GCPendingContainers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "log_pilot_gc_pending_containers",
+		Help: "Number of destroyed containers whose input files are not removed yet.",
+	}, []string{"configurer"})
+	GCRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_gc_removed_total",
+		Help: "Number of input files removed after logs drained.",
+	}, []string{"configurer"})
+	GCForceRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_gc_force_removed_total",
+		Help: "Number of input files removed by force before logs drained.",
+	}, []string{"configurer"})
+	// GCForceRemovedBytes is only reported by configurers which know sizes
+	// of log files.
+	GCForceRemovedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_gc_force_removed_bytes_total",
+		Help: "Number of bytes not shipped when input files were removed by force.",
+	}, []string{"configurer"})
+)
@@ -0,0 +1,472 @@
+package otel
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/configurer/funcs"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/fileutil"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	inputConfigVersionV0_1 = "v0.1"
+
+	// receiverPrefix is shared by all receivers generated by log-pilot.
+	receiverPrefix = "logpilot"
+	// pipelineName is the collector pipeline the receivers are added to,
+	// processors and exporters of it should be defined in the base config.
+	pipelineName = "logs/logpilot"
+	// mergedFile is the file passed to the collector with --config.
+	mergedFile = "logpilot.yaml"
+)
+
+var (
+	currentInputConfigVersion = inputConfigVersionV0_1
+)
+
+// storageStates contains file storage states of a destroyed container
+type storageStates struct {
+	*container.Container
+	states []storageFile
+	// checked is set once states are read.
+	checked   bool
+	destroyed time.Time
+	// removed is set once the receiver config has been removed, the storage
+	// files are cleaned in the next scan.
+	removed bool
+}
+
+// storageFile is a checkpoint database created by the file_storage extension.
+type storageFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+type otelConfigurer struct {
+	name string
+	base string
+	// Directory where the receiver configs and the merged config are written.
+	configDir string
+	// Directory of the file_storage extension, empty if not used.
+	storageDir string
+	// Name of the storage extension used by the receivers.
+	storage string
+	// reloadProcess is the name of the collector process signaled to reload
+	// the merged config, empty if the collector watches the file itself.
+	// reloadErr is the error of the last reload, the merged config is
+	// reloaded again until it succeeds.
+	reloadProcess  string
+	reloadErr      error
+	tmpl           *template.Template
+	closeCh        chan bool
+	gcPolicy       configurer.GCPolicy
+	watchContainer map[string]*storageStates
+	logger         log.Logger
+	lock           sync.Mutex
+}
+
+// New creates a new OpenTelemetry Collector configurer. Receiver configs of
+// containers are written into <configDir>/receivers.d, and merged into
+// <configDir>/logpilot.yaml. The collector doesn't watch its config file, it's
+// signaled to reload the merged config if reloadProcess is set.
+func New(baseDir, configTemplateFile, configDir, storage, storageDir, reloadProcess string, gcPolicy configurer.GCPolicy) (configurer.Configurer, error) {
+	t, err := template.New(filepath.Base(configTemplateFile)).Funcs(funcMap).ParseFiles(configTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parse log template: %v", err)
+	}
+
+	if storage != "" {
+		if _, err := os.Stat(storageDir); err != nil {
+			return nil, err
+		}
+	}
+
+	logger := log.NewLogger("configurer")
+	c := &otelConfigurer{
+		logger:         logger,
+		name:           "otel",
+		base:           baseDir,
+		configDir:      configDir,
+		storage:        storage,
+		storageDir:     storageDir,
+		reloadProcess:  reloadProcess,
+		tmpl:           t,
+		closeCh:        make(chan bool),
+		watchContainer: make(map[string]*storageStates, 0),
+		gcPolicy:       gcPolicy,
+	}
+
+	if err := os.MkdirAll(c.getReceiversDir(), 0755); err != nil {
+		return nil, err
+	}
+	if reloadProcess == "" {
+		logger.Warnw("Collector is not signaled to reload config, it must watch the merged config for changes", "path", c.getMergedFile())
+	}
+
+	return c, nil
+}
+
+var funcMap = funcs.FuncMap(template.FuncMap{
+	"receiverID": receiverID,
+	"exprString": exprString,
+})
+
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func receiverName(containerID, name string) string {
+	return strings.Join([]string{receiverPrefix, containerID, invalidIDChars.ReplaceAllString(name, "_")}, "_")
+}
+
+// receiverID returns the id of the filelog receiver generated for a log
+// config, e.g. filelog/logpilot_<container_id>_<name>.
+func receiverID(containerID, name string) string {
+	return "filelog/" + receiverName(containerID, name)
+}
+
+// exprString quotes s as a string literal of the operator expression language.
+func exprString(s string) string {
+	return strconv.Quote(s)
+}
+
+func (c *otelConfigurer) getReceiversDir() string {
+	return filepath.Join(c.configDir, "receivers.d")
+}
+
+func (c *otelConfigurer) getMergedFile() string {
+	return filepath.Join(c.configDir, mergedFile)
+}
+
+func (c *otelConfigurer) Name() string {
+	return c.name
+}
+
+func (c *otelConfigurer) Start() error {
+	go configurer.RunScan(c.Name(), c.gcPolicy.ScanInterval, c.closeCh, c.logger, c.scan)
+	return nil
+}
+
+func (c *otelConfigurer) Stop() {
+	close(c.closeCh)
+}
+
+// BootstrapCheck removes unknown and old version files, and returns all the
+// receiver config files.
+func (c *otelConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	ret, err := configurer.LoadInputDir(c.getReceiversDir(), currentInputConfigVersion, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.merge(); err != nil {
+		if c.reloadErr == nil {
+			return nil, err
+		}
+		// The collector may start later and load the merged config then.
+		c.logger.Warnw("Fail to reload collector", "error", err)
+	}
+	return ret, nil
+}
+
+func (c *otelConfigurer) getContainerConfigPath(con *container.Container) string {
+	return filepath.Join(c.getReceiversDir(), configurer.InputFilename(con, currentInputConfigVersion))
+}
+
+func (c *otelConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, err := c.render(ev)
+	if err != nil {
+		configurer.RenderErrors.WithLabelValues(c.Name()).Inc()
+		return fmt.Errorf("error render config file: %v", err)
+	}
+
+	confPath := c.getContainerConfigPath(&ev.Container)
+	meta := configurer.NewInputConfigFile(ev, currentInputConfigVersion, []byte(content))
+	data, err := meta.Encode([]byte(content))
+	if err != nil {
+		return fmt.Errorf("error encode config file: %v", err)
+	}
+	if err := fileutil.WriteFileAtomic(confPath, data, 0644); err != nil {
+		return fmt.Errorf("error write config file: %v", err)
+	}
+	configurer.InputsWritten.WithLabelValues(c.Name()).Inc()
+	if err := c.merge(); err != nil {
+		return fmt.Errorf("error merge config files: %v", err)
+	}
+
+	c.logger.Infow("Configuration updated successfully", ev.Container.LogFields()...)
+	return nil
+}
+
+func (c *otelConfigurer) render(ev *configurer.ContainerAddEvent) (string, error) {
+	var buf bytes.Buffer
+	context := map[string]interface{}{
+		"containerId": ev.Container.ID,
+		"configList":  ev.LogConfigs,
+		"storage":     c.storage,
+	}
+	if err := c.tmpl.Execute(&buf, context); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// merge combines all the receiver configs into one file, and adds the
+// receivers to the log-pilot pipeline. The collector does not load config
+// directories, so this is the only file it needs to know.
+func (c *otelConfigurer) merge() error {
+	dir := c.getReceiversDir()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	receivers := yaml.MapSlice{}
+	ids := []string{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".yml") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		fragment := struct {
+			Receivers yaml.MapSlice `yaml:"receivers"`
+		}{}
+		if err := yaml.Unmarshal(data, &fragment); err != nil {
+			c.logger.Warnf("ignore invalid config %s: %v", f.Name(), err)
+			continue
+		}
+		for _, item := range fragment.Receivers {
+			receivers = append(receivers, item)
+			ids = append(ids, fmt.Sprint(item.Key))
+		}
+	}
+
+	// A pipeline without receivers is invalid, keep a receiver that
+	// matches nothing until there are containers to collect.
+	if len(receivers) == 0 {
+		id := "filelog/" + receiverPrefix + "_placeholder"
+		receivers = append(receivers, yaml.MapItem{
+			Key: id,
+			Value: map[string]interface{}{
+				"include": []string{filepath.Join(c.configDir, ".placeholder")},
+			},
+		})
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	merged := yaml.MapSlice{
+		{Key: "receivers", Value: receivers},
+		{Key: "service", Value: map[string]interface{}{
+			"pipelines": map[string]interface{}{
+				pipelineName: map[string]interface{}{
+					"receivers": ids,
+				},
+			},
+		}},
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return err
+	}
+
+	if old, err := ioutil.ReadFile(c.getMergedFile()); err == nil && bytes.Equal(old, data) && c.reloadErr == nil {
+		return nil
+	}
+	if err := fileutil.WriteFileAtomic(c.getMergedFile(), data, 0644); err != nil {
+		return err
+	}
+	c.reloadErr = c.reload()
+	return c.reloadErr
+}
+
+func (c *otelConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.watchContainer[ev.Container.ID]; !ok {
+		c.watchContainer[ev.Container.ID] = &storageStates{
+			Container: &ev.Container,
+			destroyed: time.Now(),
+		}
+	}
+	c.updatePending()
+	return nil
+}
+
+// updatePending exports the number of destroyed containers whose configs
+// are not removed yet.
+func (c *otelConfigurer) updatePending() {
+	pending := 0
+	for _, st := range c.watchContainer {
+		if !st.removed {
+			pending++
+		}
+	}
+	configurer.GCPendingContainers.WithLabelValues(c.Name()).Set(float64(pending))
+}
+
+// scan gc for receiver configs and storage files of destroyed containers.
+func (c *otelConfigurer) scan() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	defer c.updatePending()
+
+	changed := false
+	for id, ss := range c.watchContainer {
+		logger := c.logger.With(ss.LogFields()...)
+		if ss.removed {
+			if err := c.removeStorage(id); err != nil {
+				logger.Errorw("Fail to remove storage", "error", err)
+				continue
+			}
+			delete(c.watchContainer, id)
+			continue
+		}
+
+		confPath := c.getContainerConfigPath(ss.Container)
+		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
+			logger.Info("Config has been removed")
+			ss.removed = true
+			continue
+		}
+
+		states, err := c.getStorageFiles(id)
+		if err != nil {
+			logger.Warnw("Fail to read storage", "error", err)
+			continue
+		}
+		ok, force := c.canRemoveConf(states, ss, time.Now())
+		if !ok {
+			logger.Debug("Config cannot be removed for now, will try to remove it in next scan")
+			continue
+		}
+		if force {
+			logger.Warnw("Remove config by force before storage settled", "path", confPath)
+		}
+
+		logger.Infow("Try to remove config", "path", confPath)
+		if err := os.Remove(confPath); err != nil {
+			logger.Errorw("Fail to remove config", "path", confPath, "error", err)
+			continue
+		}
+		ss.removed = true
+		configurer.InputsRemoved.WithLabelValues(c.Name()).Inc()
+		if force {
+			configurer.GCForceRemoved.WithLabelValues(c.Name()).Inc()
+		} else {
+			configurer.GCRemoved.WithLabelValues(c.Name()).Inc()
+		}
+		changed = true
+	}
+
+	if changed {
+		return c.merge()
+	}
+	return nil
+}
+
+// canRemoveConf returns true if the container has lingered for MinLinger,
+// and the file storage of the container did not change since last scan. The
+// storage is a bbolt database, its modification time changes whenever the
+// receivers checkpoint an offset. Without storage, offsets are kept in memory
+// and there is nothing to compare, the config is removed after MinLinger.
+// force is set if the config is removed after MaxDrain while the storage is
+// still changing.
+func (c *otelConfigurer) canRemoveConf(states []storageFile, ss *storageStates, now time.Time) (ok, force bool) {
+	changed := !ss.checked || len(states) != len(ss.states)
+	for i := 0; !changed && i < len(states); i++ {
+		changed = states[i].Name != ss.states[i].Name ||
+			states[i].Size != ss.states[i].Size ||
+			!states[i].ModTime.Equal(ss.states[i].ModTime)
+	}
+	ss.states, ss.checked = states, true
+
+	age := now.Sub(ss.destroyed)
+	if age < c.gcPolicy.MinLinger {
+		return false, false
+	}
+	if changed && c.storage != "" {
+		return age >= c.gcPolicy.MaxDrain, true
+	}
+	return true, false
+}
+
+// getStorageFiles lists storage files of a container's receivers, which are
+// named as receiver_filelog_<receiver_name>.
+func (c *otelConfigurer) getStorageFiles(containerID string) ([]storageFile, error) {
+	if c.storage == "" {
+		return nil, nil
+	}
+	pattern := "receiver_filelog_" + strings.Join([]string{receiverPrefix, containerID, "*"}, "_")
+	matches, err := filepath.Glob(filepath.Join(c.storageDir, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	var states []storageFile
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		states = append(states, storageFile{
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states, nil
+}
+
+func (c *otelConfigurer) removeStorage(containerID string) error {
+	states, err := c.getStorageFiles(containerID)
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		if err := os.Remove(filepath.Join(c.storageDir, s.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks input files can be written, and the collector reloaded the
+// merged config.
+func (c *otelConfigurer) Check() error {
+	c.lock.Lock()
+	reloadErr := c.reloadErr
+	c.lock.Unlock()
+	if reloadErr != nil {
+		return fmt.Errorf("error reload collector: %v", reloadErr)
+	}
+	return configurer.CheckWritable(c.getReceiversDir())
+}
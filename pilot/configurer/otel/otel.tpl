@@ -0,0 +1,69 @@
+{{- /*
+  filelog receivers for a single container. log-pilot merges all the
+  rendered files into logpilot.yaml, which adds the receivers to the
+  "logs/logpilot" pipeline of the collector.
+*/ -}}
+{{- $cid := .containerId -}}
+{{- $storage := .storage -}}
+receivers:
+{{- range .configList }}
+  {{- $cfg := . }}
+  {{ receiverID $cid .Name }}:
+    include:
+      - {{ quote .LogFile }}
+    start_at: beginning
+    include_file_path: true
+    {{- if $storage }}
+    storage: {{ $storage }}
+    {{- end }}
+    {{- if .Tags }}
+    resource:
+      {{- range $key, $value := .Tags }}
+      {{ quote $key }}: {{ quote $value }}
+      {{- end }}
+    {{- end }}
+    operators:
+      {{- if .Stdout }}
+      - type: json_parser
+        id: docker
+        timestamp:
+          parse_from: attributes.time
+          layout_type: gotime
+          layout: "2006-01-02T15:04:05.999999999Z07:00"
+      - type: move
+        from: attributes.log
+        to: body
+      - type: move
+        from: attributes.stream
+        to: attributes["log.iostream"]
+      {{- end }}
+      {{- with index .InOpts "multiline_pattern" }}
+      - type: recombine
+        combine_field: body
+        is_first_entry: {{ quote (printf "body matches %s" (exprString .)) }}
+        {{- if $cfg.Stdout }}
+        combine_with: ""
+        {{- end }}
+      {{- end }}
+      {{- with index .InOpts "include_lines" }}
+      - type: filter
+        expr: {{ quote (printf "body not matches %s" (exprString .)) }}
+      {{- end }}
+      {{- with index .InOpts "exclude_lines" }}
+      - type: filter
+        expr: {{ quote (printf "body matches %s" (exprString .)) }}
+      {{- end }}
+      {{- if eq .Format "json" }}
+      - type: json_parser
+        parse_from: body
+        parse_to: attributes
+        on_error: send
+      {{- end }}
+      {{- with index .InOpts "regex_pattern" }}
+      - type: regex_parser
+        regex: {{ quote . }}
+        parse_from: body
+        parse_to: attributes
+        on_error: send
+      {{- end }}
+{{- end }}
@@ -0,0 +1,169 @@
+package otel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRenderAndMerge(t *testing.T) {
+	tmpl, err := template.New("otel.tpl").Funcs(funcMap).ParseFiles("otel.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "otel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &otelConfigurer{
+		configDir: dir,
+		storage:   "file_storage",
+		tmpl:      tmpl,
+		logger:    log.NewLogger("test"),
+	}
+	if err := os.MkdirAll(c.getReceiversDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ev := configurer.ContainerAddEvent{
+		Container: container.Container{
+			ID:        "1",
+			Name:      "app",
+			Namespace: "default",
+			Pod:       "app-0",
+		},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{
+				Name:    "access",
+				LogFile: "/opt/tomcat/access.log",
+				Format:  configurer.LogFormatJSON,
+				Tags:    map[string]string{"foo": "a \"quoted\"\nvalue"},
+				InOpts: map[string]string{
+					"multiline_pattern": `^\d{4}-`,
+					"include_lines":     `"ERROR"`,
+				},
+			},
+			&configurer.LogConfig{
+				Name:    "stdout",
+				LogFile: "/var/lib/docker/containers/1/1-json.log",
+				Format:  configurer.LogFormatJSON,
+				Stdout:  true,
+			},
+		},
+	}
+	if err := c.OnAdd(&ev); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, mergedFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := struct {
+		Receivers map[string]struct {
+			Storage   string                   `yaml:"storage"`
+			Resource  map[string]string        `yaml:"resource"`
+			Operators []map[string]interface{} `yaml:"operators"`
+		} `yaml:"receivers"`
+		Service struct {
+			Pipelines map[string]struct {
+				Receivers []string `yaml:"receivers"`
+			} `yaml:"pipelines"`
+		} `yaml:"service"`
+	}{}
+	if err := yaml.Unmarshal(data, &merged); err != nil {
+		t.Fatalf("invalid yaml: %v\n%s", err, data)
+	}
+
+	access, ok := merged.Receivers["filelog/logpilot_1_access"]
+	if !ok {
+		t.Fatalf("receiver not found: %s", data)
+	}
+	if access.Storage != "file_storage" {
+		t.Errorf("expect storage file_storage, got %q", access.Storage)
+	}
+	if access.Resource["foo"] != "a \"quoted\"\nvalue" {
+		t.Errorf("unexpected resource: %#v", access.Resource)
+	}
+	if len(access.Operators) != 3 {
+		t.Errorf("expect 3 operators, got %#v", access.Operators)
+	}
+	if ids := merged.Service.Pipelines[pipelineName].Receivers; len(ids) != 2 {
+		t.Errorf("expect 2 receivers in pipeline, got %v", ids)
+	}
+}
+
+func TestCanRemoveConf(t *testing.T) {
+	policy := configurer.GCPolicy{ScanInterval: time.Minute, MinLinger: time.Minute, MaxDrain: time.Hour}
+	destroyed := time.Now()
+	files := []storageFile{{Name: "receiver_filelog_logpilot_1_app", Size: 1, ModTime: destroyed}}
+	changed := []storageFile{{Name: "receiver_filelog_logpilot_1_app", Size: 2, ModTime: destroyed}}
+
+	cases := []struct {
+		storage string
+		prev    []storageFile
+		states  []storageFile
+		age     time.Duration
+		ok      bool
+		force   bool
+	}{
+		// Never removed before MinLinger, even without storage files.
+		{"file_storage", nil, nil, 0, false, false},
+		{"", nil, nil, 0, false, false},
+		{"", nil, nil, time.Minute, true, false},
+		{"file_storage", files, files, time.Minute, true, false},
+		{"file_storage", files, changed, time.Minute, false, true},
+		{"file_storage", files, changed, time.Hour, true, true},
+	}
+	for i, cas := range cases {
+		c := &otelConfigurer{storage: cas.storage, gcPolicy: policy}
+		ss := &storageStates{destroyed: destroyed, states: cas.prev, checked: cas.prev != nil}
+		ok, force := c.canRemoveConf(cas.states, ss, destroyed.Add(cas.age))
+		if ok != cas.ok || (ok && force != cas.force) {
+			t.Errorf("case %d: expect %v/%v, got %v/%v", i, cas.ok, cas.force, ok, force)
+		}
+	}
+}
+
+func TestFindProcesses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for pid, comm := range map[string]string{
+		"1":    "pause\n",
+		"20":   "otelcol-contrib\n",
+		"21":   "otelcol-contrib\n",
+		"self": "log-pilot\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, pid, "comm"), []byte(comm), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Names longer than comm are truncated by the kernel.
+	pids, err := findProcesses(dir, "otelcol-contrib-custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(pids)
+	if len(pids) != 2 || pids[0] != 20 || pids[1] != 21 {
+		t.Errorf("expect pids [20 21], got %v", pids)
+	}
+}
@@ -0,0 +1,66 @@
+package otel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// procDir is where processes are looked up, the collector is visible if its
+// pod shares the process namespace.
+var procDir = "/proc"
+
+// maxCommLen is the max length of process names in /proc/<pid>/comm.
+const maxCommLen = 15
+
+// reload signals the collector to reload the merged config. The collector
+// reloads its config on SIGHUP.
+func (c *otelConfigurer) reload() error {
+	if c.reloadProcess == "" {
+		return nil
+	}
+	pids, err := findProcesses(procDir, c.reloadProcess)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("collector process %s not found, it must share the process namespace with log-pilot", c.reloadProcess)
+	}
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+			return fmt.Errorf("error signal collector process %d: %v", pid, err)
+		}
+		c.logger.Infow("Signaled collector to reload config", "pid", pid, "process", c.reloadProcess)
+	}
+	return nil
+}
+
+// findProcesses returns pids of processes with the name.
+func findProcesses(procDir, name string) ([]int, error) {
+	if len(name) > maxCommLen {
+		name = name[:maxCommLen]
+	}
+	dirs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		// Processes may exit while listing.
+		comm, err := ioutil.ReadFile(filepath.Join(procDir, dir.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
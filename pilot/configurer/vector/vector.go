@@ -0,0 +1,419 @@
+package vector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/configurer/funcs"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/fileutil"
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+const (
+	inputConfigVersionV0_1 = "v0.1"
+
+	// componentPrefix is shared by all components generated by log-pilot.
+	componentPrefix = "logpilot"
+)
+
+var (
+	currentInputConfigVersion = inputConfigVersionV0_1
+)
+
+// checkpointStates contains vector checkpoints of a destroyed container
+type checkpointStates struct {
+	*container.Container
+	states []checkpoint
+	// checked is set once states are read.
+	checked   bool
+	destroyed time.Time
+	// removed is set once the config file has been removed, the checkpoint
+	// directories are cleaned in the next scan.
+	removed bool
+}
+
+type vectorConfigurer struct {
+	name string
+	base string
+	// Directory loaded by vector with --config-dir.
+	configDir string
+	// Vector data_dir, where file sources keep their checkpoints.
+	dataDir        string
+	tmpl           *template.Template
+	closeCh        chan bool
+	gcPolicy       configurer.GCPolicy
+	watchContainer map[string]*checkpointStates
+	logger         log.Logger
+	lock           sync.Mutex
+}
+
+// New creates a new vector configurer.
+func New(baseDir, configTemplateFile, configDir, dataDir string, gcPolicy configurer.GCPolicy) (configurer.Configurer, error) {
+	t, err := template.New(filepath.Base(configTemplateFile)).Funcs(funcMap).ParseFiles(configTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parse log template: %v", err)
+	}
+
+	if _, err := os.Stat(dataDir); err != nil {
+		return nil, err
+	}
+
+	logger := log.NewLogger("configurer")
+	c := &vectorConfigurer{
+		logger:         logger,
+		name:           "vector",
+		base:           baseDir,
+		configDir:      configDir,
+		dataDir:        dataDir,
+		tmpl:           t,
+		closeCh:        make(chan bool),
+		watchContainer: make(map[string]*checkpointStates, 0),
+		gcPolicy:       gcPolicy,
+	}
+
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+var funcMap = funcs.FuncMap(template.FuncMap{
+	"componentID": componentID,
+	"upstream":    upstream,
+	"vrlPath":     vrlPath,
+	"vrlString":   vrlString,
+	"vrlRegex":    vrlRegex,
+})
+
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// componentID returns the id of a vector component generated for a log
+// config, e.g. logpilot_src_<container_id>_<name>.
+func componentID(kind, containerID, name string) string {
+	return strings.Join([]string{componentPrefix, kind, containerID, invalidIDChars.ReplaceAllString(name, "_")}, "_")
+}
+
+// pipeline stages of a log config, in order.
+var stages = []string{"src", "include", "exclude", "out"}
+
+// upstream returns the id of the nearest stage before the given one which is
+// generated for the log config.
+func upstream(stage, containerID string, cfg *configurer.LogConfig) (string, error) {
+	idx := -1
+	for i := range stages {
+		if stages[i] == stage {
+			idx = i
+			break
+		}
+	}
+	if idx <= 0 {
+		return "", fmt.Errorf("no upstream for stage %q", stage)
+	}
+	for i := idx - 1; i > 0; i-- {
+		if cfg.InOpts[stages[i]+"_lines"] != "" {
+			return componentID(stages[i], containerID, cfg.Name), nil
+		}
+	}
+	return componentID("src", containerID, cfg.Name), nil
+}
+
+var vrlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// vrlPath converts a dotted field name to a VRL path, segments which are
+// not identifiers are quoted. For example:
+// kubernetes.annotations.helm_sh/release -> .kubernetes.annotations."helm_sh/release"
+func vrlPath(key string) string {
+	var buf bytes.Buffer
+	for _, seg := range strings.Split(key, ".") {
+		buf.WriteByte('.')
+		if vrlIdentifier.MatchString(seg) {
+			buf.WriteString(seg)
+		} else {
+			buf.WriteString(vrlString(seg))
+		}
+	}
+	return buf.String()
+}
+
+// vrlString quotes s as a VRL string literal.
+func vrlString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&buf, `\u{%x}`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// vrlRegex quotes s as a VRL regex literal.
+func vrlRegex(s string) string {
+	return "r'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+func (c *vectorConfigurer) Name() string {
+	return c.name
+}
+
+func (c *vectorConfigurer) Start() error {
+	go configurer.RunScan(c.Name(), c.gcPolicy.ScanInterval, c.closeCh, c.logger, c.scan)
+	return nil
+}
+
+func (c *vectorConfigurer) Stop() {
+	close(c.closeCh)
+}
+
+// BootstrapCheck removes old version config files written by log-pilot, and
+// returns all the config files.
+func (c *vectorConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	// Users may put their own sinks and transforms in the directory.
+	return configurer.LoadSharedInputDir(c.configDir, currentInputConfigVersion, c.logger)
+}
+
+func (c *vectorConfigurer) getContainerConfigPath(con *container.Container) string {
+	return filepath.Join(c.configDir, configurer.InputFilename(con, currentInputConfigVersion))
+}
+
+func (c *vectorConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, err := c.render(ev)
+	if err != nil {
+		configurer.RenderErrors.WithLabelValues(c.Name()).Inc()
+		return fmt.Errorf("error render config file: %v", err)
+	}
+
+	confPath := c.getContainerConfigPath(&ev.Container)
+	meta := configurer.NewInputConfigFile(ev, currentInputConfigVersion, []byte(content))
+	data, err := meta.Encode([]byte(content))
+	if err != nil {
+		return fmt.Errorf("error encode config file: %v", err)
+	}
+	if err := fileutil.WriteFileAtomic(confPath, data, 0644); err != nil {
+		return fmt.Errorf("error write config file: %v", err)
+	}
+	configurer.InputsWritten.WithLabelValues(c.Name()).Inc()
+
+	c.logger.Infow("Configuration updated successfully", ev.Container.LogFields()...)
+	return nil
+}
+
+func (c *vectorConfigurer) render(ev *configurer.ContainerAddEvent) (string, error) {
+	var buf bytes.Buffer
+	context := map[string]interface{}{
+		"containerId": ev.Container.ID,
+		"configList":  ev.LogConfigs,
+	}
+	if err := c.tmpl.Execute(&buf, context); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *vectorConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.watchContainer[ev.Container.ID]; !ok {
+		c.watchContainer[ev.Container.ID] = &checkpointStates{
+			Container: &ev.Container,
+			destroyed: time.Now(),
+		}
+	}
+	c.updatePending()
+	return nil
+}
+
+// updatePending exports the number of destroyed containers whose configs
+// are not removed yet.
+func (c *vectorConfigurer) updatePending() {
+	pending := 0
+	for _, st := range c.watchContainer {
+		if !st.removed {
+			pending++
+		}
+	}
+	configurer.GCPendingContainers.WithLabelValues(c.Name()).Set(float64(pending))
+}
+
+// scan gc for config files and checkpoints of destroyed containers.
+func (c *vectorConfigurer) scan() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	defer c.updatePending()
+
+	for id, cs := range c.watchContainer {
+		logger := c.logger.With(cs.LogFields()...)
+		if cs.removed {
+			if err := c.removeCheckpoints(id); err != nil {
+				logger.Errorw("Fail to remove checkpoints", "error", err)
+				continue
+			}
+			delete(c.watchContainer, id)
+			continue
+		}
+
+		confPath := c.getContainerConfigPath(cs.Container)
+		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
+			logger.Info("Config has been removed")
+			cs.removed = true
+			continue
+		}
+
+		states, err := c.getCheckpoints(id)
+		if err != nil {
+			logger.Warnw("Fail to read checkpoints", "error", err)
+			continue
+		}
+		ok, force := c.canRemoveConf(states, cs, time.Now())
+		if !ok {
+			logger.Debug("Config cannot be removed for now, will try to remove it in next scan")
+			continue
+		}
+		if force {
+			logger.Warnw("Remove config by force before checkpoints settled", "path", confPath)
+		}
+
+		logger.Infow("Try to remove config", "path", confPath)
+		if err := os.Remove(confPath); err != nil {
+			logger.Errorw("Fail to remove config", "path", confPath, "error", err)
+			continue
+		}
+		cs.removed = true
+		configurer.InputsRemoved.WithLabelValues(c.Name()).Inc()
+		if force {
+			configurer.GCForceRemoved.WithLabelValues(c.Name()).Inc()
+		} else {
+			configurer.GCRemoved.WithLabelValues(c.Name()).Inc()
+		}
+	}
+	return nil
+}
+
+// canRemoveConf returns true if the container has lingered for MinLinger,
+// and its checkpoints did not change since last scan, which means vector has
+// shipped all the logs. force is set if the config is removed after MaxDrain
+// while checkpoints are still changing.
+func (c *vectorConfigurer) canRemoveConf(states []checkpoint, cs *checkpointStates, now time.Time) (ok, force bool) {
+	changed := !cs.checked || len(states) != len(cs.states)
+	for i := 0; !changed && i < len(states); i++ {
+		changed = states[i] != cs.states[i]
+	}
+	cs.states, cs.checked = states, true
+
+	age := now.Sub(cs.destroyed)
+	if age < c.gcPolicy.MinLinger {
+		return false, false
+	}
+	if changed {
+		return age >= c.gcPolicy.MaxDrain, true
+	}
+	return true, false
+}
+
+// checkpointsFile is the layout of <data_dir>/<source_id>/checkpoints.json.
+type checkpointsFile struct {
+	Version     string `json:"version"`
+	Checkpoints []struct {
+		Fingerprint json.RawMessage `json:"fingerprint"`
+		Position    uint64          `json:"position"`
+	} `json:"checkpoints"`
+}
+
+// checkpoint is the read position of a file fingerprint.
+type checkpoint struct {
+	Source      string
+	Fingerprint string
+	Position    uint64
+}
+
+func (c *vectorConfigurer) getCheckpointDirs(containerID string) ([]string, error) {
+	pattern := strings.Join([]string{componentPrefix, "src", containerID, "*"}, "_")
+	return filepath.Glob(filepath.Join(c.dataDir, pattern))
+}
+
+func (c *vectorConfigurer) getCheckpoints(containerID string) ([]checkpoint, error) {
+	dirs, err := c.getCheckpointDirs(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []checkpoint
+	for _, dir := range dirs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "checkpoints.json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		f := checkpointsFile{}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("error decode %s: %v", dir, err)
+		}
+		for _, cp := range f.Checkpoints {
+			states = append(states, checkpoint{
+				Source:      filepath.Base(dir),
+				Fingerprint: string(cp.Fingerprint),
+				Position:    cp.Position,
+			})
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Source != states[j].Source {
+			return states[i].Source < states[j].Source
+		}
+		return states[i].Fingerprint < states[j].Fingerprint
+	})
+	return states, nil
+}
+
+func (c *vectorConfigurer) removeCheckpoints(containerID string) error {
+	dirs, err := c.getCheckpointDirs(containerID)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks input files can be written.
+func (c *vectorConfigurer) Check() error {
+	return configurer.CheckWritable(c.configDir)
+}
@@ -0,0 +1,98 @@
+package vector
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRender(t *testing.T) {
+	tmpl, err := template.New("vector.tpl").Funcs(funcMap).ParseFiles("vector.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &vectorConfigurer{
+		tmpl: tmpl,
+	}
+	ev := configurer.ContainerAddEvent{
+		Container: container.Container{
+			ID: "1",
+		},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{
+				Name:    "access",
+				LogFile: "/opt/tomcat/access.log",
+				Format:  configurer.LogFormatPlain,
+				Tags: map[string]string{
+					"foo":                                    "bar",
+					"kubernetes.annotations.helm_sh/release": `a "quoted" value`,
+				},
+				InOpts: map[string]string{
+					"multiline_pattern": "^\\d{4}-\n",
+					"exclude_lines":     `^DEBUG 'x'`,
+				},
+			},
+			&configurer.LogConfig{
+				Name:    "stdout",
+				LogFile: "/var/lib/docker/containers/1/1-json.log",
+				Format:  configurer.LogFormatJSON,
+				Tags:    map[string]string{"foo": "bar"},
+				Stdout:  true,
+			},
+		},
+	}
+	content, err := c.render(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := struct {
+		Sources    map[string]map[string]interface{} `yaml:"sources"`
+		Transforms map[string]struct {
+			Type   string   `yaml:"type"`
+			Inputs []string `yaml:"inputs"`
+			Source string   `yaml:"source"`
+		} `yaml:"transforms"`
+	}{}
+	if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+		t.Fatalf("invalid yaml: %v\n%s", err, content)
+	}
+
+	if len(cfg.Sources) != 2 {
+		t.Errorf("expect 2 sources, got %d", len(cfg.Sources))
+	}
+	exclude, ok := cfg.Transforms["logpilot_exclude_1_access"]
+	if !ok || exclude.Inputs[0] != "logpilot_src_1_access" {
+		t.Errorf("unexpected exclude transform: %#v", exclude)
+	}
+	out, ok := cfg.Transforms["logpilot_out_1_access"]
+	if !ok || out.Inputs[0] != "logpilot_exclude_1_access" {
+		t.Errorf("unexpected out transform: %#v", out)
+	}
+	if !strings.Contains(out.Source, `.kubernetes.annotations."helm_sh/release" = "a \"quoted\" value"`) {
+		t.Errorf("tags not mapped: %s", out.Source)
+	}
+	stdout, ok := cfg.Transforms["logpilot_out_1_stdout"]
+	if !ok || stdout.Inputs[0] != "logpilot_src_1_stdout" {
+		t.Errorf("unexpected stdout transform: %#v", stdout)
+	}
+}
+
+func TestVRLPath(t *testing.T) {
+	cases := map[string]string{
+		"foo":                      ".foo",
+		"kubernetes.pod_name":      ".kubernetes.pod_name",
+		"kubernetes.labels.app/v1": `.kubernetes.labels."app/v1"`,
+	}
+	for key, expect := range cases {
+		if got := vrlPath(key); got != expect {
+			t.Errorf("expect %s, got %s", expect, got)
+		}
+	}
+}
@@ -9,3 +9,14 @@ type Container struct {
 	PodID string
 	// Container Name
 }
+
+// LogFields returns identity of the container as key/value pairs of log
+// fields.
+func (c *Container) LogFields() []interface{} {
+	return []interface{}{
+		"namespace", c.Namespace,
+		"pod", c.Pod,
+		"container", c.Name,
+		"container_id", c.ID,
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
 	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/health"
 	"github.com/caicloud/log-pilot/pilot/kube"
 	"github.com/caicloud/log-pilot/pilot/log"
 
@@ -18,7 +19,7 @@ import (
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/elastic/beats/libbeat/logp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Discovery watchs container start and destory events,
@@ -26,6 +27,10 @@ import (
 type Discovery interface {
 	Start() error
 	Stop()
+	// Healthz returns checks of liveness.
+	Healthz() []health.Check
+	// Readyz returns checks of readiness.
+	Readyz() []health.Check
 }
 
 // containerInfo saves basic informations for a container
@@ -35,6 +40,8 @@ type containerInfo struct {
 	// Compatible with old interface, which use pod annotation to store
 	// log sources.
 	LegacyLogSources []string
+	// Profile is the default template profile of logs in the pod.
+	Profile string
 }
 
 type discovery struct {
@@ -47,13 +54,20 @@ type discovery struct {
 	logPrefixes     []string
 	existContainers map[string]*containerInfo
 	cache           kube.Cache
+	recorder        kube.Recorder
 	mutex           sync.Mutex
 	bListNS         map[string]struct{} // blacklisted namespaces
 	wListNS         map[string]struct{} // whitelisted namespaces
+	status          status
+	// statusWriter writes podStatus keyed by <namespace>/<pod>, it's nil if
+	// collection status is not written.
+	statusWriter kube.StatusWriter
+	podStatus    map[string]*kube.CollectionStatus
 }
 
-// New creates a new Discovery
-func New(baseDir, logPrefix string, configurer configurer.Configurer, bListNS, wListNS []string) (Discovery, error) {
+// New creates a new Discovery. If writeStatus is true, collection status is
+// written to pod annotations.
+func New(baseDir, logPrefix string, configurer configurer.Configurer, bListNS, wListNS []string, writeStatus bool) (Discovery, error) {
 	if os.Getenv("DOCKER_API_VERSION") == "" {
 		os.Setenv("DOCKER_API_VERSION", "1.23")
 	}
@@ -63,16 +77,9 @@ func New(baseDir, logPrefix string, configurer configurer.Configurer, bListNS, w
 		return nil, fmt.Errorf("error create docker client: %v", err)
 	}
 
-	var prefixes []string
-	if logPrefix == "" {
-		prefixes = []string{"log_"}
-	} else {
-		for _, each := range strings.Split(logPrefix, ",") {
-			prefixes = append(prefixes, each+"_log_")
-		}
-	}
+	prefixes := parseLogPrefixes(logPrefix)
 
-	logger := logp.NewLogger("discovery")
+	logger := log.NewLogger("discovery")
 	logger.Info("Use log prefix:", logPrefix)
 
 	cache, err := kube.New()
@@ -80,20 +87,59 @@ func New(baseDir, logPrefix string, configurer configurer.Configurer, bListNS, w
 		return nil, fmt.Errorf("error create pod cache: %v", err)
 	}
 
+	recorder, err := kube.NewRecorder()
+	if err != nil {
+		logger.Warnf("Events of pods are disabled: %v", err)
+	}
+
+	var statusWriter kube.StatusWriter
+	if writeStatus {
+		if statusWriter, err = kube.NewStatusWriter(); err != nil {
+			return nil, fmt.Errorf("error create status writer: %v", err)
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	return &discovery{
+	d := &discovery{
 		ctx:             ctx,
 		cancel:          cancel,
 		logger:          logger,
 		configurer:      configurer,
 		client:          client,
 		cache:           cache,
+		recorder:        recorder,
+		statusWriter:    statusWriter,
+		podStatus:       make(map[string]*kube.CollectionStatus),
 		base:            baseDir,
 		logPrefixes:     prefixes,
 		existContainers: make(map[string]*containerInfo),
 		bListNS:         listToSet(bListNS),
 		wListNS:         listToSet(wListNS),
-	}, nil
+	}
+	containers := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "log_pilot_containers",
+		Help: "Number of containers whose logs are collected.",
+	}, func() float64 {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return float64(len(d.existContainers))
+	})
+	// The gauge of a discovery created before, e.g. in tests, is replaced.
+	prometheus.Unregister(containers)
+	prometheus.MustRegister(containers)
+	return d, nil
+}
+
+// parseLogPrefixes returns prefixes of log env names.
+func parseLogPrefixes(logPrefix string) []string {
+	if logPrefix == "" {
+		return []string{"log_"}
+	}
+	var prefixes []string
+	for _, each := range strings.Split(logPrefix, ",") {
+		prefixes = append(prefixes, each+"_log_")
+	}
+	return prefixes
 }
 
 // Start runs a work loop
@@ -103,13 +149,18 @@ func (d *discovery) Start() error {
 	if err := d.cache.Start(d.ctx.Done()); err != nil {
 		return fmt.Errorf("error start pod cache: %v", err)
 	}
+	d.status.update(func(s *status) { s.cacheSynced = true })
 	d.logger.Info("Cache synced")
 
+	if n, ok := d.configurer.(configurer.LossNotifier); ok {
+		n.NotifyLoss(d.reportLoss)
+	}
 	if err := d.configurer.Start(); err != nil {
 		return err
 	}
 	d.logger.Info("Configurer started")
 
+	bootstrapTs := time.Now()
 	collected, err := d.configurer.BootstrapCheck()
 	if err != nil {
 		return fmt.Errorf("bootstrap check failed: %v", err)
@@ -121,16 +172,19 @@ func (d *discovery) Start() error {
 		return fmt.Errorf("error process all containers for the first time: %v", err)
 	}
 	d.logger.Infof("Cost %v to process all events", time.Since(startTs))
+	bootstrapDuration.Set(time.Since(bootstrapTs).Seconds())
 
 	// Remove configuration files if container not exist
-	for ID, info := range collected {
-		if _, exist := d.existContainers[ID]; !exist {
+	for _, info := range collected {
+		if _, exist := d.existContainers[info.ContainerID]; !exist {
 			if err := os.Remove(info.Path); err != nil {
 				return err
 			}
 		}
 	}
 
+	d.status.update(func(s *status) { s.bootstrapped = true })
+
 	if err := d.watch(); err != nil {
 		return err
 	}
@@ -147,6 +201,16 @@ func (d *discovery) watch() error {
 		Filters: filter,
 	}
 	msgs, errs := d.client.Events(ctx, options)
+	now := time.Now()
+	d.status.update(func(s *status) {
+		s.watching = true
+		s.lastPing = now
+		s.lastEvent = now
+	})
+	defer d.status.update(func(s *status) { s.watching = false })
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
 	for {
 		select {
 		case <-ctx.Done():
@@ -156,9 +220,23 @@ func (d *discovery) watch() error {
 		}
 
 		select {
+		case <-heartbeat.C:
+			if err := d.ping(); err != nil {
+				d.logger.Warnf("error ping docker: %v", err)
+				continue
+			}
+			d.status.update(func(s *status) { s.lastPing = time.Now() })
+			d.checkMissedEvents(filter)
 		case msg := <-msgs:
+			action := eventAction(msg.Action)
+			eventsReceived.WithLabelValues(action).Inc()
+			lastEventTimestamp.Set(float64(time.Now().Unix()))
+			d.status.update(func(s *status) { s.lastEvent = time.Unix(0, msg.TimeNano) })
 			if err := d.processEvent(msg); err != nil {
-				d.logger.Errorf("fail to process event: %v,  %v", msg, err)
+				eventsFailed.WithLabelValues(action).Inc()
+				d.logger.Errorw("Fail to process event", append(labelFields(msg.Actor.ID, msg.Actor.Attributes), "action", msg.Action, "error", err)...)
+			} else {
+				eventsProcessed.WithLabelValues(action).Inc()
 			}
 		case err := <-errs:
 			d.logger.Warnf("error: %v", err)
@@ -187,7 +265,7 @@ func (d *discovery) processAllContainers() error {
 			return err
 		}
 		if err = d.newContainer(&containerJSON); err != nil {
-			d.logger.Errorf("fail to process container %s: %v", containerJSON.Name, err)
+			d.logger.Errorw("Fail to process container", append(labelFields(c.ID, c.Labels), "error", err)...)
 			continue
 		}
 	}
@@ -195,6 +273,17 @@ func (d *discovery) processAllContainers() error {
 	return nil
 }
 
+// labelFields returns identity of the container from its labels as log
+// fields, which are the same as container.Container.LogFields.
+func labelFields(id string, labels map[string]string) []interface{} {
+	return []interface{}{
+		"namespace", labels[labelPodNamespace],
+		"pod", labels[labelPodName],
+		"container", labels[labelContainerName],
+		"container_id", id,
+	}
+}
+
 func getContainerInfo(cache kube.Cache, containerJSON *types.ContainerJSON) *containerInfo {
 	ret := &containerInfo{}
 	ret.ID = containerJSON.ID
@@ -208,14 +297,17 @@ func getContainerInfo(cache kube.Cache, containerJSON *types.ContainerJSON) *con
 	if ret.Pod != "" && ret.Namespace != "" {
 		ret.ReleaseMeta = cache.GetReleaseMeta(ret.Namespace, ret.Pod)
 		ret.LegacyLogSources = cache.GetLegacyLogSources(ret.Namespace, ret.Pod, ret.Name)
+		ret.Profile = cache.GetProfile(ret.Namespace, ret.Pod)
 	}
 	return ret
 }
 
-func (d *discovery) addContainer(ID string, info *containerInfo) {
+func (d *discovery) addContainer(ID string, info *containerInfo, logConfigs []*configurer.LogConfig) {
+	written := d.writtenStatus(info)
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	d.existContainers[ID] = info
+	d.setContainerStatus(info, logConfigs, written)
 }
 
 func (d *discovery) processEvent(msg events.Message) error {
@@ -223,9 +315,10 @@ func (d *discovery) processEvent(msg events.Message) error {
 	ctx := context.Background()
 	switch msg.Action {
 	case "start", "restart":
-		d.logger.Infof("Process container start event: %s", containerID)
+		logger := d.logger.With(labelFields(containerID, msg.Actor.Attributes)...)
+		logger.Info("Process container start event")
 		if d.exists(containerID) {
-			d.logger.Infof("%s is already exists.", containerID)
+			logger.Info("Container already exists")
 			return nil
 		}
 		containerJSON, err := d.client.ContainerInspect(ctx, containerID)
@@ -234,10 +327,11 @@ func (d *discovery) processEvent(msg events.Message) error {
 		}
 		return d.newContainer(&containerJSON)
 	case "destroy":
-		d.logger.Infof("Process container destory event: %s", containerID)
+		logger := d.logger.With(labelFields(containerID, msg.Actor.Attributes)...)
+		logger.Info("Process container destroy event")
 		err := d.delContainer(containerID)
 		if err != nil {
-			d.logger.Warnf("Process container destory event error: %s, %s", containerID, err.Error())
+			logger.Warnw("Fail to process container destroy event", "error", err)
 		}
 	}
 	return nil
@@ -262,15 +356,20 @@ func (d *discovery) newContainer(containerJSON *types.ContainerJSON) error {
 		}
 	}
 
-	log.Debug("container info:", *info)
+	logger := d.logger.With(info.LogFields()...)
+	logger.Debugw("Container info", "release_meta", info.ReleaseMeta, "legacy_log_sources", info.LegacyLogSources, "profile", info.Profile)
 
-	logConfigs, err := parseLogConfigs(d, info, containerJSON)
+	logConfigs, warnings, err := parseLogConfigs(d, info, containerJSON)
 	if err != nil {
 		return err
 	}
+	for _, w := range warnings {
+		logger.Warnw("Invalid log config", "image", containerJSON.Image, "problem", w.String())
+		d.warnPod(&info.Container, reasonInvalidLogConfig, "Container %s: %s", info.Name, w)
+	}
 
 	if len(logConfigs) == 0 {
-		d.logger.Debugf("No log collecting config for container %s", containerJSON.ID)
+		logger.Debug("No log collecting config")
 		return nil
 	}
 
@@ -280,10 +379,11 @@ func (d *discovery) newContainer(containerJSON *types.ContainerJSON) error {
 	}
 
 	if err := d.configurer.OnAdd(ev); err != nil {
+		d.warnPod(&info.Container, reasonLogConfigFailed, "Failed to configure log collection of container %s: %v", info.Name, err)
 		return fmt.Errorf("error update config: %v", err)
 	}
 
-	d.addContainer(containerJSON.ID, info)
+	d.addContainer(containerJSON.ID, info, logConfigs)
 
 	return nil
 }
@@ -294,6 +394,7 @@ func (d *discovery) delContainer(ID string) error {
 
 	if info, exist := d.existContainers[ID]; exist {
 		delete(d.existContainers, ID)
+		d.setContainerStatus(info, nil, nil)
 		return d.configurer.OnDestroy(&configurer.ContainerDestroyEvent{
 			Container: info.Container,
 		})
@@ -0,0 +1,35 @@
+package discovery
+
+import (
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/kube"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons of events recorded on pods.
+const (
+	// reasonInvalidLogConfig means a log config of the pod is ignored.
+	reasonInvalidLogConfig = "InvalidLogConfig"
+	// reasonLogConfigFailed means configurers failed to collect logs of the
+	// container.
+	reasonLogConfigFailed = "LogConfigFailed"
+	// reasonLogLoss means logs may be lost before collected.
+	reasonLogLoss = "LogLoss"
+)
+
+// warnPod records a warning event of the pod the container belongs to,
+// events are dropped if the recorder is not available.
+func (d *discovery) warnPod(c *container.Container, reason, format string, args ...interface{}) {
+	if d.recorder == nil || c.Pod == "" {
+		return
+	}
+	d.recorder.Eventf(kube.PodRef{Namespace: c.Namespace, Name: c.Pod, UID: c.PodID}, corev1.EventTypeWarning, reason, format, args...)
+}
+
+// reportLoss records an event of the pod on suspected log loss.
+func (d *discovery) reportLoss(loss *configurer.Loss) {
+	d.warnPod(&loss.Container, reasonLogLoss, "Logs of container %s may be lost, %s was %s before shipped, about %d bytes not collected",
+		loss.Container.Name, loss.File, loss.Reason, loss.Bytes)
+}
@@ -0,0 +1,163 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/health"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	// heartbeatInterval is the interval to ping docker while watching
+	// events, quiet nodes may have no event for a long time.
+	heartbeatInterval = 30 * time.Second
+	// staleAfter is the time without successful heartbeats, after which the
+	// event stream is considered dead.
+	staleAfter = 3 * heartbeatInterval
+	// pingTimeout is the timeout to ping docker.
+	pingTimeout = 5 * time.Second
+	// deliverDelay is the time allowed for an event to go through the
+	// stream, events older than it and not received are missed.
+	deliverDelay = 10 * time.Second
+)
+
+// status is the state of discovery reported by health checks.
+type status struct {
+	lock         sync.Mutex
+	cacheSynced  bool
+	bootstrapped bool
+	watching     bool
+	// lastPing is the time of the last successful heartbeat, which only
+	// proves docker is reachable.
+	lastPing time.Time
+	// lastEvent is the time of the last event received, or the time
+	// watching started.
+	lastEvent time.Time
+	// missed is the number of events docker emitted after lastEvent but
+	// never delivered through the stream.
+	missed int
+}
+
+func (s *status) update(fn func(s *status)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fn(s)
+}
+
+// Healthz returns checks of liveness, which fail if the event stream is dead,
+// it recovers only after restart. Errors of Start are fatal and not reported
+// here.
+func (d *discovery) Healthz() []health.Check {
+	return []health.Check{
+		{Name: "event-stream", Fn: d.checkEventStream},
+	}
+}
+
+// Readyz returns checks of readiness, which fail until the pod cache is
+// synced and bootstrap completes, or if docker or the configurer is not
+// working.
+func (d *discovery) Readyz() []health.Check {
+	checks := append(d.Healthz(),
+		health.Check{Name: "pod-cache", Fn: d.checkCacheSynced},
+		health.Check{Name: "bootstrap", Fn: d.checkBootstrapped},
+		health.Check{Name: "runtime", Fn: d.ping},
+	)
+	if c, ok := d.configurer.(configurer.Checker); ok {
+		checks = append(checks, health.Check{Name: "configurer", Fn: c.Check})
+	}
+	return checks
+}
+
+func (d *discovery) checkEventStream() error {
+	d.status.lock.Lock()
+	defer d.status.lock.Unlock()
+	if !d.status.bootstrapped {
+		// Events are watched after bootstrap.
+		return nil
+	}
+	if !d.status.watching {
+		return fmt.Errorf("event stream closed")
+	}
+	if since := time.Since(d.status.lastPing); since > staleAfter {
+		return fmt.Errorf("no heartbeat in %v", since.Round(time.Second))
+	}
+	if d.status.missed > 0 {
+		return fmt.Errorf("event stream hung, %d events missed since %v", d.status.missed, d.status.lastEvent.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkMissedEvents asks docker for events emitted after the last one
+// received, a quiet node has none, while a hung stream misses them.
+func (d *discovery) checkMissedEvents(filter filters.Args) {
+	d.status.lock.Lock()
+	since := d.status.lastEvent.Add(time.Nanosecond)
+	d.status.lock.Unlock()
+	until := time.Now().Add(-deliverDelay)
+	if !until.After(since) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, pingTimeout)
+	defer cancel()
+	msgs, errs := d.client.Events(ctx, types.EventsOptions{
+		Since:   timestamp(since),
+		Until:   timestamp(until),
+		Filters: filter,
+	})
+	missed := 0
+	for {
+		select {
+		case <-msgs:
+			missed++
+		case err := <-errs:
+			if err != io.EOF {
+				d.logger.Warnf("error list events: %v", err)
+				return
+			}
+			if missed > 0 {
+				d.logger.Errorw("Event stream missed events", "since", since, "count", missed)
+			}
+			d.status.update(func(s *status) { s.missed = missed })
+			return
+		}
+	}
+}
+
+// timestamp formats t as the timestamp of docker API.
+func timestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+func (d *discovery) checkCacheSynced() error {
+	d.status.lock.Lock()
+	defer d.status.lock.Unlock()
+	if !d.status.cacheSynced {
+		return fmt.Errorf("pod cache not synced")
+	}
+	return nil
+}
+
+func (d *discovery) checkBootstrapped() error {
+	d.status.lock.Lock()
+	defer d.status.lock.Unlock()
+	if !d.status.bootstrapped {
+		return fmt.Errorf("bootstrap not completed")
+	}
+	return nil
+}
+
+// ping checks docker is reachable.
+func (d *discovery) ping() error {
+	ctx, cancel := context.WithTimeout(d.ctx, pingTimeout)
+	defer cancel()
+	_, err := d.client.Ping(ctx)
+	return err
+}
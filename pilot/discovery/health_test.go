@@ -0,0 +1,33 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckEventStream(t *testing.T) {
+	cases := []struct {
+		name         string
+		bootstrapped bool
+		watching     bool
+		lastPing     time.Duration
+		missed       int
+		healthy      bool
+	}{
+		{"bootstrapping", false, false, 0, 0, true},
+		{"watching", true, true, time.Second, 0, true},
+		{"closed", true, false, time.Second, 0, false},
+		{"stale", true, true, staleAfter + time.Second, 0, false},
+		{"hung", true, true, time.Second, 1, false},
+	}
+	for _, cas := range cases {
+		d := &discovery{}
+		d.status.bootstrapped = cas.bootstrapped
+		d.status.watching = cas.watching
+		d.status.lastPing = time.Now().Add(-cas.lastPing)
+		d.status.missed = cas.missed
+		if err := d.checkEventStream(); (err == nil) != cas.healthy {
+			t.Errorf("%s: expect healthy %v, got %v", cas.name, cas.healthy, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package discovery
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/kube"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InspectOptions are options of discovery used to inspect containers.
+type InspectOptions struct {
+	BaseDir   string
+	LogPrefix string
+	BListNS   []string
+	WListNS   []string
+	// Cache provides pod informations, NewStaticCache can be used to
+	// inspect pod manifests.
+	Cache kube.Cache
+}
+
+// Inspect parses log configs of the container as discovery does, without
+// sending them to configurers. Reasons why logs are not collected are
+// returned as warnings.
+func Inspect(opts *InspectOptions, containerJSON *types.ContainerJSON) (*configurer.ContainerAddEvent, []string, error) {
+	d := &discovery{
+		logger:      log.NewLogger("discovery"),
+		base:        opts.BaseDir,
+		logPrefixes: parseLogPrefixes(opts.LogPrefix),
+		cache:       opts.Cache,
+		bListNS:     listToSet(opts.BListNS),
+		wListNS:     listToSet(opts.WListNS),
+	}
+
+	info := getContainerInfo(d.cache, containerJSON)
+	warnings := []string{}
+	if len(containerJSON.Config.Labels) > 0 {
+		if info.Name == "POD" {
+			return nil, append(warnings, "sandbox container of pod is ignored"), nil
+		}
+		if !d.isResponsible(info.Namespace) {
+			warnings = append(warnings, fmt.Sprintf("namespace %q is ignored by namespace filters", info.Namespace))
+		}
+	}
+	if info.Pod == "" {
+		warnings = append(warnings, "container is not created by kubernetes, pod informations are missing")
+	}
+
+	logConfigs, w, err := parseLogConfigs(d, info, containerJSON)
+	if err != nil {
+		return nil, warnings, err
+	}
+	for i := range w {
+		warnings = append(warnings, w[i].String())
+	}
+	if len(logConfigs) == 0 {
+		warnings = append(warnings, "no log to collect")
+	}
+
+	return &configurer.ContainerAddEvent{
+		Container:  info.Container,
+		LogConfigs: logConfigs,
+	}, warnings, nil
+}
+
+// emptyDirPath returns host path of the emptyDir volume.
+func emptyDirPath(podID, volume string) string {
+	return fmt.Sprintf("/var/lib/kubelet/pods/%s/volumes/kubernetes.io~empty-dir/%s", podID, volume)
+}
+
+// SimulateContainer converts a container in the pod manifest to the result
+// of docker inspect. Mounts of emptyDir and hostPath volumes are simulated,
+// mounts maps container paths to host paths, which override the simulated
+// ones. Env which can't be resolved without a cluster are ignored and
+// returned as warnings.
+func SimulateContainer(pod *corev1.Pod, name, id string, mounts map[string]string) (*types.ContainerJSON, []string, error) {
+	var c *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == name || (name == "" && len(pod.Spec.Containers) == 1) {
+			c = &pod.Spec.Containers[i]
+			break
+		}
+	}
+	if c == nil {
+		return nil, nil, fmt.Errorf("container %q not found in pod %s", name, pod.Name)
+	}
+
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	podID := string(pod.UID)
+	if podID == "" {
+		podID = "00000000-0000-0000-0000-000000000000"
+	}
+
+	warnings := []string{}
+	env := []string{}
+	for _, e := range c.Env {
+		if e.ValueFrom != nil {
+			warnings = append(warnings, fmt.Sprintf("env %s is set from a reference, which is ignored", e.Name))
+			continue
+		}
+		env = append(env, e.Name+"="+e.Value)
+	}
+	if len(c.EnvFrom) > 0 {
+		warnings = append(warnings, "envFrom is ignored")
+	}
+
+	volumes := map[string]corev1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+	mountPoints := []types.MountPoint{}
+	for _, vm := range c.VolumeMounts {
+		v, ok := volumes[vm.Name]
+		if !ok {
+			return nil, warnings, fmt.Errorf("volume %q of mount %s not found", vm.Name, vm.MountPath)
+		}
+		var source string
+		switch {
+		case v.EmptyDir != nil:
+			source = emptyDirPath(podID, v.Name)
+		case v.HostPath != nil:
+			source = v.HostPath.Path
+		default:
+			continue
+		}
+		if _, ok := mounts[vm.MountPath]; ok {
+			continue
+		}
+		mountPoints = append(mountPoints, types.MountPoint{
+			Name:        v.Name,
+			Source:      filepath.Join(source, vm.SubPath),
+			Destination: vm.MountPath,
+			RW:          !vm.ReadOnly,
+		})
+	}
+	for dest, source := range mounts {
+		mountPoints = append(mountPoints, types.MountPoint{
+			Source:      source,
+			Destination: dest,
+			RW:          true,
+		})
+	}
+
+	return &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    id,
+			Name:  "/" + c.Name,
+			Image: c.Image,
+		},
+		Mounts: mountPoints,
+		Config: &dockercontainer.Config{
+			Image: c.Image,
+			Env:   env,
+			Labels: map[string]string{
+				labelPodName:       pod.Name,
+				labelPodNamespace:  namespace,
+				labelPodID:         podID,
+				labelContainerName: c.Name,
+			},
+		},
+	}, warnings, nil
+}
+
+// DeclaredLogSources returns file log sources of the container in the pod
+// manifest, which are declared by env, or the legacy annotation if no log env
+// is set, as parseLogConfigs does. Sources of env set from references are
+// unknown and ignored.
+func DeclaredLogSources(logPrefix string, pod *corev1.Pod, c *corev1.Container) ([]string, error) {
+	prefixes := parseLogPrefixes(logPrefix)
+	isLogEnvSet := false
+	sources := []string{}
+	for _, e := range c.Env {
+		name, opt := parseLogsEnv(prefixes, e.Name)
+		if name == "" && opt == "" {
+			continue
+		}
+		isLogEnvSet = true
+		if opt != "" || name == "stdout" || e.ValueFrom != nil {
+			continue
+		}
+		sources = append(sources, e.Value)
+	}
+	if isLogEnvSet {
+		return sources, nil
+	}
+	return kube.LegacyLogSources(pod, c.Name)
+}
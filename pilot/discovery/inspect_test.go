@@ -0,0 +1,94 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInspectPod(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-0",
+			Namespace:   "demo",
+			UID:         "uid-1",
+			Annotations: map[string]string{"logging.caicloud.io/profile": "java"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "app",
+				Env: []corev1.EnvVar{
+					{Name: "caicloud_log_access", Value: "/opt/tomcat/logs/access.log"},
+					{Name: "caicloud_log_access_format", Value: "yaml"},
+					{Name: "caicloud_log_debug", Value: "/tmp/debug.log"},
+					{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{}},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "logs", MountPath: "/opt/tomcat/logs"},
+				},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "logs",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}},
+		},
+	}
+
+	containerJSON, warnings, err := SimulateContainer(pod, "", "abc", map[string]string{"/data": "/mnt/data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "POD_IP") {
+		t.Errorf("expect warning of POD_IP, got %v", warnings)
+	}
+	if len(containerJSON.Mounts) != 2 {
+		t.Errorf("expect 2 mounts, got %#v", containerJSON.Mounts)
+	}
+
+	ev, warnings, err := Inspect(&InspectOptions{
+		BaseDir:   "/host",
+		LogPrefix: "caicloud",
+		Cache:     kube.NewStaticCache(pod),
+	}, containerJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Container.Namespace != "demo" || ev.Container.PodID != "uid-1" || ev.Container.Name != "app" {
+		t.Errorf("unexpected container %#v", ev.Container)
+	}
+
+	expectWarnings := []string{`unknown format "yaml"`, "/tmp/debug.log"}
+	if len(warnings) != len(expectWarnings) {
+		t.Errorf("expect %d warnings, got %v", len(expectWarnings), warnings)
+	}
+	for _, e := range expectWarnings {
+		found := false
+		for _, w := range warnings {
+			found = found || strings.Contains(w, e)
+		}
+		if !found {
+			t.Errorf("expect warning %q, got %v", e, warnings)
+		}
+	}
+
+	files := map[string]string{}
+	for _, cfg := range ev.LogConfigs {
+		files[cfg.Name] = cfg.LogFile
+		if cfg.Profile != "java" {
+			t.Errorf("expect profile from pod annotation, got %q", cfg.Profile)
+		}
+	}
+	expectFiles := map[string]string{
+		"stdout": "/host/var/lib/docker/containers/abc/abc-json.log",
+		"access": "/host/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~empty-dir/logs/access.log",
+	}
+	for name, f := range expectFiles {
+		if files[name] != f {
+			t.Errorf("expect log file %s of %s, got %s", f, name, files[name])
+		}
+	}
+}
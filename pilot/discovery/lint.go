@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/kube"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// LintOptions are options to lint pod manifests.
+type LintOptions struct {
+	LogPrefix string
+	// Pods in namespaces of BListNS, or not in WListNS if it's not empty,
+	// are ignored by discovery, they have no problem.
+	BListNS []string
+	WListNS []string
+	// Profiles are template profiles which can be used by logs, profiles are
+	// not checked if it's nil.
+	Profiles []string
+}
+
+// Problem is a mistake of logging configs in the pod manifest, which makes
+// some logs not collected, or collected in an unexpected way.
+type Problem struct {
+	// Container is empty for problems of the pod.
+	Container string
+	Message   string
+	// Fatal is set if some logs will never be collected because of the
+	// problem.
+	Fatal bool
+}
+
+func (p Problem) String() string {
+	if p.Container == "" {
+		return p.Message
+	}
+	return fmt.Sprintf("container %s: %s", p.Container, p.Message)
+}
+
+// LintPod checks log env of containers and logging annotations of the pod,
+// problems are found by parsing log configs as discovery does, with mounts
+// of emptyDir and hostPath volumes. Pods ignored by the namespace filters
+// have no problem.
+func LintPod(opts *LintOptions, pod *corev1.Pod) []Problem {
+	problems := []Problem{}
+	d := &discovery{
+		logger:      log.NewLogger("discovery"),
+		base:        "/",
+		logPrefixes: parseLogPrefixes(opts.LogPrefix),
+		bListNS:     listToSet(opts.BListNS),
+		wListNS:     listToSet(opts.WListNS),
+	}
+	if !d.isResponsible(pod.Namespace) {
+		return problems
+	}
+
+	for _, msg := range kube.CheckLegacyLogFiles(pod) {
+		problems = append(problems, Problem{Message: msg, Fatal: true})
+	}
+	if len(problems) > 0 {
+		// Reported above, instead of being logged by the cache.
+		pod = kube.WithoutLegacyLogFiles(pod)
+	}
+
+	d.cache = kube.NewStaticCache(pod)
+	var profiles map[string]struct{}
+	if opts.Profiles != nil {
+		profiles = listToSet(opts.Profiles)
+	}
+
+	for _, c := range pod.Spec.Containers {
+		add := func(fatal bool, format string, args ...interface{}) {
+			problems = append(problems, Problem{Container: c.Name, Message: fmt.Sprintf(format, args...), Fatal: fatal})
+		}
+
+		for _, p := range d.lintEnv(c.Env) {
+			add(p.Fatal, "%s", p.Message)
+		}
+
+		containerJSON, _, err := SimulateContainer(pod, c.Name, "lint", nil)
+		if err != nil {
+			add(true, "%v", err)
+			continue
+		}
+		info := getContainerInfo(d.cache, containerJSON)
+		logConfigs, warnings, err := parseLogConfigs(d, info, containerJSON)
+		if err != nil {
+			add(true, "%v", err)
+			continue
+		}
+		sort.Slice(warnings, func(i, j int) bool { return warnings[i].message < warnings[j].message })
+		for _, w := range warnings {
+			add(w.fatal, "%s", w.message)
+		}
+		if profiles == nil {
+			continue
+		}
+		for _, cfg := range logConfigs {
+			if _, ok := profiles[cfg.Profile]; cfg.Profile != "" && !ok {
+				add(false, "unknown profile %q of log %s, the default template is used", cfg.Profile, cfg.Name)
+			}
+		}
+	}
+	return problems
+}
+
+// lintEnv finds log env which are not parsed as expected. An option with
+// unknown suffix, e.g. caicloud_log_access_multiline, is silently treated as
+// another log named access_multiline, whose value is not a path usually.
+// Values of options are checked too, an invalid value makes the collector
+// fail to load the log.
+func (d *discovery) lintEnv(envs []corev1.EnvVar) []Problem {
+	problems := []Problem{}
+	names := map[string]string{}
+	for _, e := range envs {
+		name, opt := parseLogsEnv(d.logPrefixes, e.Name)
+		if name == "" && opt == "" {
+			continue
+		}
+		if e.ValueFrom != nil {
+			continue
+		}
+		if opt == "" {
+			names[name] = e.Name
+			continue
+		}
+		if err := checkOption(opt, e.Value); err != nil {
+			problems = append(problems, Problem{Message: fmt.Sprintf("invalid value of env %s: %v", e.Name, err), Fatal: true})
+		}
+	}
+
+	for name, env := range names {
+		for other := range names {
+			if other == name || !strings.HasPrefix(name, other+"_") {
+				continue
+			}
+			suffix := strings.TrimPrefix(name, other+"_")
+			problems = append(problems, Problem{Message: fmt.Sprintf("env %s is treated as log %s, since %q is not an option of log %s, valid options are %s",
+				env, name, suffix, other, strings.Join(validOptions, ", "))})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Message < problems[j].Message })
+	return problems
+}
+
+// regexOptions are options whose values are regular expressions, filebeat
+// fails to load the input if any of them is invalid.
+var regexOptions = map[string]bool{
+	"multiline_pattern": true,
+	"include_lines":     true,
+	"exclude_lines":     true,
+	"regex_pattern":     true,
+}
+
+// checkOption checks the value of a log option, format is checked while
+// parsing log configs.
+func checkOption(opt, v string) error {
+	if regexOptions[opt] {
+		if _, err := regexp.Compile(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
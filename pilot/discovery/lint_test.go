@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLintPod(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-0",
+			Namespace: "demo",
+			Annotations: map[string]string{
+				"logging.caicloud.io/logfiles": `{"files": [{"container": "app", "realPath": "/log/a.log"}`,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "app",
+				Env: []corev1.EnvVar{
+					{Name: "caicloud_log_access", Value: "/opt/tomcat/logs/access.log"},
+					{Name: "caicloud_log_access_multiline", Value: `^\d`},
+					{Name: "caicloud_log_access_profile", Value: "java"},
+					{Name: "caicloud_log_access_include_lines", Value: "^(ERROR"},
+					{Name: "caicloud_log_gc", Value: "logs/gc.log"},
+					{Name: "caicloud_log_debug", Value: "/tmp/debug.log"},
+					{Name: "caicloud_log_error", Value: "/opt/tomcat/logs/error.log"},
+					{Name: "caicloud_log_error_profile", Value: "nginx"},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "logs", MountPath: "/opt/tomcat/logs"},
+				},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "logs",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}},
+		},
+	}
+
+	problems := LintPod(&LintOptions{LogPrefix: "caicloud", Profiles: []string{"java"}}, pod)
+	expects := []string{
+		"logging.caicloud.io/logfiles is ignored",
+		`"multiline" is not an option of log access`,
+		// The misspelled option is also parsed as a log of its own.
+		"log source ^\\d of log access_multiline: expect absolute path",
+		"log gc: expect absolute path",
+		"log source /tmp/debug.log of log debug: not on a volume",
+		`unknown profile "nginx" of log error`,
+		"invalid value of env caicloud_log_access_include_lines",
+	}
+	if len(problems) != len(expects) {
+		t.Errorf("expect %d problems, got %v", len(expects), problems)
+	}
+	for _, e := range expects {
+		found := false
+		for _, p := range problems {
+			found = found || strings.Contains(p.String(), e)
+		}
+		if !found {
+			t.Errorf("expect problem %q, got %v", e, problems)
+		}
+	}
+
+	pod.Annotations = nil
+	pod.Spec.Containers[0].Env = pod.Spec.Containers[0].Env[:1]
+	if problems := LintPod(&LintOptions{LogPrefix: "caicloud"}, pod); len(problems) != 0 {
+		t.Errorf("expect no problem, got %v", problems)
+	}
+}
@@ -0,0 +1,40 @@
+package discovery
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	eventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_docker_events_received_total",
+		Help: "Number of docker container events received.",
+	}, []string{"action"})
+	eventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_docker_events_processed_total",
+		Help: "Number of docker container events processed successfully.",
+	}, []string{"action"})
+	eventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_pilot_docker_events_failed_total",
+		Help: "Number of docker container events failed to process.",
+	}, []string{"action"})
+	lastEventTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "log_pilot_docker_last_event_timestamp_seconds",
+		Help: "Unix time when the last docker container event was received.",
+	})
+	bootstrapDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "log_pilot_bootstrap_duration_seconds",
+		Help: "Time taken to bootstrap configurers and process all existing containers.",
+	})
+)
+
+// eventAction returns the action label of the docker event. Actions like
+// exec_start contain the command, they are counted as other with actions
+// ignored by discovery.
+func eventAction(action string) string {
+	switch action {
+	case "start", "restart", "destroy":
+		return action
+	}
+	return "other"
+}
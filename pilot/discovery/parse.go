@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
-	"github.com/caicloud/log-pilot/pilot/log"
 	"github.com/docker/docker/api/types"
 )
 
@@ -61,7 +60,9 @@ func putIfNotEmpty(store map[string]string, key, value string) {
 
 type logOptionsSet map[string]*logOptions
 
-func (ls logOptionsSet) insert(name, opt, v string) {
+// insert sets an option of the log, it returns a warning if the option is
+// invalid.
+func (ls logOptionsSet) insert(name, opt, v string) string {
 	if _, exist := ls[name]; !exist {
 		ls[name] = &logOptions{
 			name:         name,
@@ -76,14 +77,22 @@ func (ls logOptionsSet) insert(name, opt, v string) {
 				ls[name].format = configurer.LogFormatJSON
 			} else {
 				ls[name].format = configurer.LogFormatPlain
+				if v != configurer.LogFormatPlain {
+					return fmt.Sprintf("unknown format %q of log %s, use plain", v, name)
+				}
 			}
-			return
+			return ""
+		}
+		if opt == "profile" {
+			ls[name].profile = v
+			return ""
 		}
 
 		ls[name].inputOptions[opt] = v
 	} else {
 		ls[name].source = v
 	}
+	return ""
 }
 
 // logOptions contains options for one log file
@@ -92,6 +101,8 @@ type logOptions struct {
 	name   string
 	source string
 	format configurer.LogFormat
+	// profile selects the template to render the log.
+	profile string
 
 	// inputOptions defines log collecting options.
 	inputOptions map[string]string
@@ -99,10 +110,26 @@ type logOptions struct {
 	tags map[string]string
 }
 
-func parseLogConfigs(d *discovery, info *containerInfo, containerJSON *types.ContainerJSON) ([]*configurer.LogConfig, error) {
+// parseWarning is a problem of log configs found while parsing.
+type parseWarning struct {
+	message string
+	// fatal is set if the log is skipped, it's collected in a fallback way
+	// otherwise.
+	fatal bool
+}
+
+func (w parseWarning) String() string {
+	return w.message
+}
+
+// parseLogConfigs parses log configs from env of the container and legacy
+// pod annotation. Logs which can't be collected are skipped, and the reasons
+// are returned as fatal warnings.
+func parseLogConfigs(d *discovery, info *containerInfo, containerJSON *types.ContainerJSON) ([]*configurer.LogConfig, []parseWarning, error) {
 	logOptsSet := logOptionsSet{}
 	envMap := parseEnvToMap(containerJSON.Config.Env)
 	isLogEnvSet := false
+	warnings := []parseWarning{}
 
 	for k, v := range envMap {
 		name, opt := parseLogsEnv(d.logPrefixes, k)
@@ -111,7 +138,9 @@ func parseLogConfigs(d *discovery, info *containerInfo, containerJSON *types.Con
 		}
 
 		isLogEnvSet = true
-		logOptsSet.insert(name, opt, v)
+		if w := logOptsSet.insert(name, opt, v); w != "" {
+			warnings = append(warnings, parseWarning{message: w})
+		}
 	}
 
 	// Default to collect stdout.
@@ -129,8 +158,14 @@ func parseLogConfigs(d *discovery, info *containerInfo, containerJSON *types.Con
 	}
 
 	// Check legacy log sources
+	if isLogEnvSet && len(info.LegacyLogSources) > 0 {
+		warnings = append(warnings, parseWarning{
+			message: fmt.Sprintf("log sources %v in pod annotation are ignored since env is set", info.LegacyLogSources),
+			fatal:   true,
+		})
+	}
 	if !isLogEnvSet && len(info.LegacyLogSources) > 0 {
-		log.Debug("add legacy sources:", info.LegacyLogSources)
+		d.logger.Debug("add legacy sources:", info.LegacyLogSources)
 		for i, source := range info.LegacyLogSources {
 			name := fmt.Sprintf("legacy_%v", i)
 			logOptsSet[name] = &logOptions{
@@ -143,6 +178,7 @@ func parseLogConfigs(d *discovery, info *containerInfo, containerJSON *types.Con
 	ret := []*configurer.LogConfig{}
 	for _, opts := range logOptsSet {
 		if opts.name == "" {
+			warnings = append(warnings, parseWarning{message: "log without name is ignored", fatal: true})
 			continue
 		}
 		if opts.name == "stdout" && opts.source != "true" {
@@ -156,16 +192,22 @@ func parseLogConfigs(d *discovery, info *containerInfo, containerJSON *types.Con
 		for k, v := range info.ReleaseMeta {
 			opts.tags[k] = v
 		}
+		if opts.profile == "" {
+			opts.profile = info.Profile
+		}
 		cfg, err := parseLogConfig(d, d.base, containerJSON, opts, mountsMap)
 		if err != nil {
-			log.Errorf("error parse log source %s(image %s): %v", opts.source, containerJSON.Image, err)
+			warnings = append(warnings, parseWarning{
+				message: fmt.Sprintf("error parse log source %s of log %s: %v", opts.source, opts.name, err),
+				fatal:   true,
+			})
 			continue
 		}
 
 		ret = append(ret, cfg)
 	}
 
-	return ret, nil
+	return ret, warnings, nil
 }
 
 func parseLogConfig(d *discovery, base string, containerJSON *types.ContainerJSON, opts *logOptions, mountsMap map[string]types.MountPoint) (*configurer.LogConfig, error) {
@@ -181,7 +223,7 @@ func parseLogConfig(d *discovery, base string, containerJSON *types.ContainerJSO
 	} else {
 		hostPath = hostDirOf(opts.source, mountsMap)
 		if hostPath == "" {
-			return nil, fmt.Errorf("cannot found file %s on host", opts.source)
+			return nil, fmt.Errorf("not on a volume")
 		}
 	}
 
@@ -192,6 +234,7 @@ func parseLogConfig(d *discovery, base string, containerJSON *types.ContainerJSO
 		InOpts:  opts.inputOptions,
 		Tags:    opts.tags,
 		Stdout:  isStdout,
+		Profile: opts.profile,
 	}
 
 	return ret, nil
@@ -236,7 +279,9 @@ func getMountMap(containerJSON *types.ContainerJSON) map[string]types.MountPoint
 
 // definitions of multiline_pattern, include_lines, exclude_lines can be found in
 // https://github.com/elastic/beats/blob/v6.4.2/filebeat/filebeat.reference.yml
-var validOptions = []string{"multiline_pattern", "include_lines", "exclude_lines"}
+// regex_pattern is used by configurers which support parsing with regex.
+// format and profile select how the log is parsed and which template is used.
+var validOptions = []string{"multiline_pattern", "include_lines", "exclude_lines", "regex_pattern", "format", "profile"}
 
 func parseLogsEnv(prefixes []string, key string) (name, opt string) {
 	var (
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/kube"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// writtenStatus returns the status in the pod annotation, which may be
+// written before log-pilot restarts. The cache may call the API server on a
+// miss, so it must be called without mutex held.
+func (d *discovery) writtenStatus(info *containerInfo) *kube.CollectionStatus {
+	if d.statusWriter == nil || info.Pod == "" {
+		return nil
+	}
+	return d.cache.GetCollectionStatus(info.Namespace, info.Pod)
+}
+
+// setContainerStatus updates collection status of the container in its pod
+// and writes it, nil logConfigs mean the container is destroyed. Sources
+// collected before, including those in written, keep their since time. It
+// must be called with mutex held.
+func (d *discovery) setContainerStatus(info *containerInfo, logConfigs []*configurer.LogConfig, written *kube.CollectionStatus) {
+	if d.statusWriter == nil || info.Pod == "" {
+		return
+	}
+	key := info.Namespace + "/" + info.Pod
+	status, ok := d.podStatus[key]
+	if !ok {
+		status = &kube.CollectionStatus{Node: kube.NodeName()}
+	}
+
+	if logConfigs == nil {
+		// The container may have been replaced by a restarted one.
+		if cs := status.Container(info.Name); cs == nil || cs.ID != info.ID {
+			return
+		}
+		status.SetContainer(kube.ContainerStatus{Name: info.Name})
+	} else {
+		status.SetContainer(kube.ContainerStatus{
+			Name:    info.Name,
+			ID:      info.ID,
+			Sources: sourceStatus(info, logConfigs, status, written),
+		})
+	}
+
+	if len(status.Containers) == 0 {
+		delete(d.podStatus, key)
+	} else {
+		d.podStatus[key] = status
+	}
+	copied := *status
+	d.statusWriter.WriteStatus(kube.PodRef{Namespace: info.Namespace, Name: info.Pod, UID: info.PodID}, &copied)
+}
+
+func sourceStatus(info *containerInfo, logConfigs []*configurer.LogConfig, status, written *kube.CollectionStatus) []kube.SourceStatus {
+	since := map[string]metav1.Time{}
+	prevs := []*kube.ContainerStatus{status.Container(info.Name)}
+	if written != nil {
+		prevs = append(prevs, written.Container(info.Name))
+	}
+	for _, prev := range prevs {
+		if prev == nil || prev.ID != info.ID {
+			continue
+		}
+		for _, s := range prev.Sources {
+			if _, ok := since[s.Name]; !ok {
+				since[s.Name] = s.Since
+			}
+		}
+	}
+
+	now := metav1.NewTime(time.Now())
+	ret := make([]kube.SourceStatus, 0, len(logConfigs))
+	for _, cfg := range logConfigs {
+		s := kube.SourceStatus{
+			Name:    cfg.Name,
+			Path:    cfg.Tags["filePath"],
+			Format:  string(cfg.Format),
+			Profile: cfg.Profile,
+			Since:   now,
+		}
+		if cfg.Stdout {
+			s.Path = "stdout"
+		}
+		if t, ok := since[cfg.Name]; ok {
+			s.Since = t
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeStatusWriter map[kube.PodRef]*kube.CollectionStatus
+
+func (w fakeStatusWriter) WriteStatus(pod kube.PodRef, status *kube.CollectionStatus) {
+	w[pod] = status
+}
+
+func TestSetContainerStatus(t *testing.T) {
+	since := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	written, _ := json.Marshal(&kube.CollectionStatus{Containers: []kube.ContainerStatus{{
+		Name:    "app",
+		ID:      "1",
+		Sources: []kube.SourceStatus{{Name: "stdout", Path: "stdout", Since: since}},
+	}}})
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "app-0",
+		UID:         "uid",
+		Annotations: map[string]string{kube.AnnotationStatus: string(written)},
+	}}
+	w := fakeStatusWriter{}
+	d := &discovery{
+		cache:        kube.NewStaticCache(pod),
+		statusWriter: w,
+		podStatus:    make(map[string]*kube.CollectionStatus),
+	}
+	ref := kube.PodRef{Namespace: "default", Name: "app-0", UID: "uid"}
+
+	app := &containerInfo{Container: container.Container{ID: "1", Namespace: "default", Pod: "app-0", PodID: "uid", Name: "app"}}
+	d.setContainerStatus(app, []*configurer.LogConfig{
+		{Name: "stdout", Stdout: true, Format: configurer.LogFormatJSON},
+		{Name: "access", LogFile: "/host/access.log", Tags: map[string]string{"filePath": "/var/log/access.log"}},
+	}, d.writtenStatus(app))
+	sidecar := &containerInfo{Container: container.Container{ID: "2", Namespace: "default", Pod: "app-0", PodID: "uid", Name: "sidecar"}}
+	d.setContainerStatus(sidecar, []*configurer.LogConfig{{Name: "stdout", Stdout: true}}, d.writtenStatus(sidecar))
+
+	cs := w[ref].Container("app")
+	if cs == nil || len(cs.Sources) != 2 || len(w[ref].Containers) != 2 {
+		t.Fatalf("unexpected status %+v", w[ref])
+	}
+	if s := cs.Sources[0]; s.Name != "access" || s.Path != "/var/log/access.log" || s.Since.Equal(&since) {
+		t.Errorf("unexpected source %+v", s)
+	}
+	if s := cs.Sources[1]; s.Path != "stdout" || s.Format != "json" || !s.Since.Equal(&since) {
+		t.Errorf("expect since of stdout kept, got %+v", s)
+	}
+
+	d.setContainerStatus(&containerInfo{Container: container.Container{ID: "0", Namespace: "default", Pod: "app-0", Name: "sidecar"}}, nil, nil)
+	if len(w[ref].Containers) != 2 {
+		t.Errorf("expect status of restarted container kept, got %+v", w[ref])
+	}
+	d.setContainerStatus(app, nil, nil)
+	d.setContainerStatus(sidecar, nil, nil)
+	if len(w[ref].Containers) != 0 || len(d.podStatus) != 0 {
+		t.Errorf("expect status removed, got %+v", w[ref])
+	}
+}
@@ -0,0 +1,44 @@
+// Package health serves health checks in the format of kubernetes, e.g.
+// /healthz and /readyz.
+package health
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// Check is a named health check, Fn returns nil if healthy.
+type Check struct {
+	Name string
+	Fn   func() error
+}
+
+// Handler runs the checks on every request. It responds 200 if all of them
+// passed, or 503 with the failures otherwise. All the checks are listed if
+// the request has the verbose query parameter.
+func Handler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verbose := r.URL.Query()["verbose"]
+		buf := &bytes.Buffer{}
+		failed := false
+		for _, c := range checks {
+			if err := c.Fn(); err != nil {
+				failed = true
+				fmt.Fprintf(buf, "[-]%s failed: %v\n", c.Name, err)
+			} else if verbose {
+				fmt.Fprintf(buf, "[+]%s ok\n", c.Name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			buf.WriteString("check failed\n")
+		} else {
+			buf.WriteString("ok\n")
+		}
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,31 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ok := Check{Name: "ok", Fn: func() error { return nil }}
+	bad := Check{Name: "bad", Fn: func() error { return fmt.Errorf("broken") }}
+
+	cases := []struct {
+		checks []Check
+		url    string
+		code   int
+		body   string
+	}{
+		{[]Check{ok}, "/healthz", http.StatusOK, "ok\n"},
+		{[]Check{ok}, "/healthz?verbose", http.StatusOK, "[+]ok ok\nok\n"},
+		{[]Check{ok, bad}, "/healthz", http.StatusServiceUnavailable, "[-]bad failed: broken\ncheck failed\n"},
+	}
+	for _, cas := range cases {
+		rec := httptest.NewRecorder()
+		Handler(cas.checks...).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, cas.url, nil))
+		if rec.Code != cas.code || rec.Body.String() != cas.body {
+			t.Errorf("%s: expect %d %q, got %d %q", cas.url, cas.code, cas.body, rec.Code, rec.Body.String())
+		}
+	}
+}
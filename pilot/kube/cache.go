@@ -2,11 +2,12 @@ package kube
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/caicloud/log-pilot/pilot/log"
 
 	"github.com/caicloud/clientset/kubernetes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -20,6 +21,10 @@ type Cache interface {
 	Start(stopCh <-chan struct{}) error
 	GetReleaseMeta(namespace, pod string) map[string]string
 	GetLegacyLogSources(namespace, pod, container string) []string
+	// GetProfile returns the template profile declared by pod annotation.
+	GetProfile(namespace, pod string) string
+	// GetCollectionStatus returns the status in pod annotation, or nil.
+	GetCollectionStatus(namespace, pod string) *CollectionStatus
 }
 
 // New create a new Cache
@@ -32,7 +37,7 @@ func New() (Cache, error) {
 	if err != nil {
 		return nil, err
 	}
-	nodeName := os.Getenv("NODE_NAME")
+	nodeName := NodeName()
 	if nodeName == "" {
 		return nil, fmt.Errorf("NODE_NAME env not defined")
 	}
@@ -41,18 +46,48 @@ func New() (Cache, error) {
 		return nil, err
 	}
 	return &kubeCache{
-		pc: pc,
+		pc:     pc,
+		run:    pc.lwCache.Run,
+		logger: log.NewLogger("kube"),
 	}, nil
 }
 
+// podGetter gets pods by namespace and name.
+type podGetter interface {
+	Get(namespace, name string) (*corev1.Pod, error)
+}
+
 type kubeCache struct {
-	pc *podsCache
+	pc podGetter
+	// run starts the informer, it's nil if pods are not watched.
+	run    func(stopCh <-chan struct{}) error
+	logger log.Logger
 }
 
 func (c *kubeCache) Start(stopCh <-chan struct{}) error {
-	return c.pc.lwCache.Run(stopCh)
+	if c.run == nil {
+		return nil
+	}
+	return c.run(stopCh)
 }
 
+var (
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "log_pilot_kube_cache_misses_total",
+		Help: "Number of pods not found in cache and got from the API server.",
+	})
+	apiErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "log_pilot_kube_api_errors_total",
+		Help: "Number of errors getting pods from the API server.",
+	})
+)
+
+const (
+	// annotationProfile selects templates for logs of the pod, which
+	// encode conventions such as multiline patterns of java or nginx.
+	annotationProfile = "logging.caicloud.io/profile"
+)
+
 var (
 	releaseAnnotationKeys = map[string]string{
 		"helm.sh/namespace": "kubernetes.annotations.helm_sh/namespace",
@@ -86,7 +121,7 @@ func releaseMeta(pod *corev1.Pod) map[string]string {
 func (c *kubeCache) GetReleaseMeta(namespace, name string) map[string]string {
 	pod, err := c.pc.Get(namespace, name)
 	if err != nil {
-		log.Errorf("error get pod from cache: %v", err)
+		c.logger.Errorw("Error get pod from cache", "namespace", namespace, "pod", name, "error", err)
 		return nil
 	}
 	return releaseMeta(pod)
@@ -95,7 +130,7 @@ func (c *kubeCache) GetReleaseMeta(namespace, name string) map[string]string {
 func (c *kubeCache) GetLegacyLogSources(namespace, podName, containerName string) []string {
 	pod, err := c.pc.Get(namespace, podName)
 	if err != nil {
-		log.Errorf("error get pod from cache: %v", err)
+		c.logger.Errorw("Error get pod from cache", "namespace", namespace, "pod", podName, "error", err)
 		return nil
 	}
 
@@ -105,11 +140,29 @@ func (c *kubeCache) GetLegacyLogSources(namespace, podName, containerName string
 
 	sources, err := extractLogSources(pod, containerName)
 	if err != nil {
-		log.Errorf("error decode log sources from pod annotation: %v", err)
+		c.logger.Errorw("Error decode log sources from pod annotation", "namespace", namespace, "pod", podName, "container", containerName, "error", err)
 	}
 	return sources
 }
 
+func (c *kubeCache) GetProfile(namespace, podName string) string {
+	pod, err := c.pc.Get(namespace, podName)
+	if err != nil {
+		c.logger.Errorw("Error get pod from cache", "namespace", namespace, "pod", podName, "error", err)
+		return ""
+	}
+	return pod.Annotations[annotationProfile]
+}
+
+func (c *kubeCache) GetCollectionStatus(namespace, podName string) *CollectionStatus {
+	pod, err := c.pc.Get(namespace, podName)
+	if err != nil {
+		c.logger.Errorw("Error get pod from cache", "namespace", namespace, "pod", podName, "error", err)
+		return nil
+	}
+	return collectionStatus(pod)
+}
+
 type podsCache struct {
 	lwCache *ListWatchCache
 	kc      kubernetes.Interface
@@ -142,8 +195,10 @@ func (tc *podsCache) Get(namespace, key string) (*corev1.Pod, error) {
 			return pod.DeepCopy(), nil
 		}
 	}
+	cacheMisses.Inc()
 	pod, e := tc.kc.CoreV1().Pods(namespace).Get(key, metav1.GetOptions{})
 	if e != nil {
+		apiErrors.Inc()
 		return nil, e
 	}
 	return pod, nil
@@ -0,0 +1,238 @@
+package kube
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"github.com/caicloud/clientset/kubernetes"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// eventComponent is the source component of events.
+	eventComponent = "log-pilot"
+	// eventDedupWindow is the period in which identical events of a pod are
+	// merged into one by increasing its count.
+	eventDedupWindow = 10 * time.Minute
+	// eventBurst and eventInterval limit API calls for events of a pod.
+	// Merged events are counted but not sent when limited.
+	eventBurst    = 5
+	eventInterval = time.Minute
+	// eventQueueSize is the number of events waiting to be sent, events are
+	// dropped when the queue is full.
+	eventQueueSize = 256
+)
+
+// PodRef refers to the pod which events are about.
+type PodRef struct {
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// Recorder records events of pods.
+type Recorder interface {
+	// Eventf records an event without blocking, eventType is Normal or
+	// Warning of corev1. Events are rate limited and deduplicated per pod.
+	Eventf(pod PodRef, eventType, reason, format string, args ...interface{})
+}
+
+// eventSink sends events to the API server.
+type eventSink interface {
+	Create(event *corev1.Event) (*corev1.Event, error)
+	Update(event *corev1.Event) (*corev1.Event, error)
+}
+
+type clientSink struct {
+	kc kubernetes.Interface
+}
+
+func (s *clientSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	return s.kc.CoreV1().Events(event.Namespace).Create(event)
+}
+
+func (s *clientSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	return s.kc.CoreV1().Events(event.Namespace).Update(event)
+}
+
+// NewRecorder creates a recorder which creates events in the cluster
+// log-pilot runs in.
+func NewRecorder() (Recorder, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := newRecorder(&clientSink{kc: kc}, NodeName())
+	go r.run()
+	return r, nil
+}
+
+// eventKey identifies identical events.
+type eventKey struct {
+	pod       PodRef
+	eventType string
+	reason    string
+	message   string
+}
+
+// eventUpdate is an event to send, update is set if the event was created
+// before.
+type eventUpdate struct {
+	event  *corev1.Event
+	update bool
+}
+
+// podLimiter limits events of a pod.
+type podLimiter struct {
+	limiter *rate.Limiter
+	last    time.Time
+}
+
+type recorder struct {
+	sink   eventSink
+	host   string
+	logger log.Logger
+	now    func() time.Time
+
+	lock sync.Mutex
+	// recent are events recorded in eventDedupWindow.
+	recent    map[eventKey]*corev1.Event
+	limiters  map[PodRef]*podLimiter
+	lastPrune time.Time
+	queue     chan *eventUpdate
+}
+
+func newRecorder(sink eventSink, host string) *recorder {
+	return &recorder{
+		sink:     sink,
+		host:     host,
+		logger:   log.NewLogger("kube"),
+		now:      time.Now,
+		recent:   make(map[eventKey]*corev1.Event),
+		limiters: make(map[PodRef]*podLimiter),
+		queue:    make(chan *eventUpdate, eventQueueSize),
+	}
+}
+
+func (r *recorder) Eventf(pod PodRef, eventType, reason, format string, args ...interface{}) {
+	key := eventKey{
+		pod:       pod,
+		eventType: eventType,
+		reason:    reason,
+		message:   fmt.Sprintf(format, args...),
+	}
+	now := r.now()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.prune(now)
+
+	u := &eventUpdate{}
+	if prev, ok := r.recent[key]; ok {
+		event := prev.DeepCopy()
+		event.Count++
+		event.LastTimestamp = metav1.NewTime(now)
+		r.recent[key] = event
+		u.event, u.update = event, true
+	} else {
+		u.event = r.newEvent(key, now)
+	}
+
+	l, ok := r.limiters[pod]
+	if !ok {
+		l = &podLimiter{limiter: rate.NewLimiter(rate.Every(eventInterval), eventBurst)}
+		r.limiters[pod] = l
+	}
+	l.last = now
+	if !l.limiter.AllowN(now, 1) {
+		r.logger.Debugw("Event is limited", "namespace", pod.Namespace, "pod", pod.Name, "reason", reason)
+		return
+	}
+	r.recent[key] = u.event
+
+	select {
+	case r.queue <- u:
+	default:
+		r.logger.Warnw("Event queue is full, drop event", "namespace", pod.Namespace, "pod", pod.Name, "reason", reason)
+	}
+}
+
+func (r *recorder) newEvent(key eventKey, now time.Time) *corev1.Event {
+	t := metav1.NewTime(now)
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", key.pod.Name, now.UnixNano()),
+			Namespace: key.pod.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  key.pod.Namespace,
+			Name:       key.pod.Name,
+			UID:        types.UID(key.pod.UID),
+		},
+		Reason:         key.reason,
+		Message:        key.message,
+		Source:         corev1.EventSource{Component: eventComponent, Host: r.host},
+		FirstTimestamp: t,
+		LastTimestamp:  t,
+		Count:          1,
+		Type:           key.eventType,
+	}
+}
+
+// prune forgets events and limiters not used in eventDedupWindow, it runs
+// at most once per eventInterval.
+func (r *recorder) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < eventInterval {
+		return
+	}
+	r.lastPrune = now
+	for key, event := range r.recent {
+		if now.Sub(event.LastTimestamp.Time) > eventDedupWindow {
+			delete(r.recent, key)
+		}
+	}
+	for pod, l := range r.limiters {
+		if now.Sub(l.last) > eventDedupWindow {
+			delete(r.limiters, pod)
+		}
+	}
+}
+
+// run sends queued events one by one, so updates of an event are sent after
+// it's created.
+func (r *recorder) run() {
+	for u := range r.queue {
+		r.send(u)
+	}
+}
+
+func (r *recorder) send(u *eventUpdate) {
+	event := u.event
+	var err error
+	if u.update {
+		_, err = r.sink.Update(event)
+		if errors.IsNotFound(err) {
+			// Expired or failed to create, create a new one.
+			_, err = r.sink.Create(event)
+		}
+	} else {
+		_, err = r.sink.Create(event)
+	}
+	if err != nil {
+		r.logger.Warnw("Error send event", "namespace", event.Namespace, "pod", event.InvolvedObject.Name, "reason", event.Reason, "error", err)
+	}
+}
@@ -0,0 +1,59 @@
+package kube
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRecorder(t *testing.T) {
+	now := time.Now()
+	r := newRecorder(nil, "node-1")
+	r.now = func() time.Time { return now }
+	pod := PodRef{Namespace: "default", Name: "app-0", UID: "uid"}
+
+	drain := func() []*eventUpdate {
+		var ret []*eventUpdate
+		for {
+			select {
+			case u := <-r.queue:
+				ret = append(ret, u)
+			default:
+				return ret
+			}
+		}
+	}
+
+	r.Eventf(pod, corev1.EventTypeWarning, "InvalidLogConfig", "log source %s is not on a volume", "/a.log")
+	r.Eventf(pod, corev1.EventTypeWarning, "InvalidLogConfig", "log source %s is not on a volume", "/a.log")
+	sent := drain()
+	if len(sent) != 2 || sent[0].update || !sent[1].update {
+		t.Fatalf("expect an event created then updated, got %v", sent)
+	}
+	if sent[0].event.Count != 1 || sent[1].event.Count != 2 || sent[1].event.Name != sent[0].event.Name {
+		t.Errorf("expect duplicated event merged, got %+v", sent[1].event)
+	}
+
+	for i := 0; i < 2*eventBurst; i++ {
+		r.Eventf(pod, corev1.EventTypeWarning, "InvalidLogConfig", "log source %s is not on a volume", "/b.log")
+	}
+	sent = drain()
+	if len(sent) != eventBurst-2 {
+		t.Fatalf("expect %d events sent within burst, got %d", eventBurst-2, len(sent))
+	}
+
+	now = now.Add(eventInterval)
+	r.Eventf(pod, corev1.EventTypeWarning, "InvalidLogConfig", "log source %s is not on a volume", "/b.log")
+	sent = drain()
+	if len(sent) != 1 || sent[0].event.Count != int32(2*eventBurst+1) {
+		t.Fatalf("expect limited events counted, got %v", sent)
+	}
+
+	now = now.Add(eventDedupWindow + eventInterval)
+	r.Eventf(pod, corev1.EventTypeWarning, "InvalidLogConfig", "log source %s is not on a volume", "/a.log")
+	sent = drain()
+	if len(sent) != 1 || sent[0].update {
+		t.Fatalf("expect a new event after dedup window, got %v", sent)
+	}
+}
@@ -44,3 +44,47 @@ func extractLogSources(pod *corev1.Pod, container string) ([]string, error) {
 	}
 	return sources, nil
 }
+
+// LegacyLogSources returns log sources of the container declared by the
+// legacy annotation of the pod.
+func LegacyLogSources(pod *corev1.Pod, container string) ([]string, error) {
+	if !requireFileLog(pod) {
+		return nil, nil
+	}
+	return extractLogSources(pod, container)
+}
+
+// CheckLegacyLogFiles checks the legacy annotation of log files, it returns
+// problems which make the annotation ignored or partially ignored.
+func CheckLegacyLogFiles(pod *corev1.Pod) []string {
+	if !requireFileLog(pod) {
+		return nil
+	}
+	items := LogFiles{}
+	if err := json.Unmarshal([]byte(pod.Annotations[annotationLogFiles]), &items); err != nil {
+		return []string{fmt.Sprintf("annotation %s is ignored, error decode: %v", annotationLogFiles, err)}
+	}
+
+	containers := map[string]bool{}
+	for _, c := range pod.Spec.Containers {
+		containers[c.Name] = true
+	}
+	problems := []string{}
+	for i, logFile := range items.Files {
+		if !containers[logFile.Container] {
+			problems = append(problems, fmt.Sprintf("file %d in annotation %s refers to unknown container %q", i, annotationLogFiles, logFile.Container))
+		}
+		if logFile.Source == "" {
+			problems = append(problems, fmt.Sprintf("file %d in annotation %s has no realPath", i, annotationLogFiles))
+		}
+	}
+	return problems
+}
+
+// WithoutLegacyLogFiles returns a copy of the pod without the legacy
+// annotation of log files.
+func WithoutLegacyLogFiles(pod *corev1.Pod) *corev1.Pod {
+	pod = pod.DeepCopy()
+	delete(pod.Annotations, annotationLogFiles)
+	return pod
+}
@@ -0,0 +1,34 @@
+package kube
+
+import (
+	"fmt"
+
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// staticPods are pods given by users, keyed by <namespace>/<name>.
+type staticPods map[string]*corev1.Pod
+
+func (p staticPods) Get(namespace, name string) (*corev1.Pod, error) {
+	pod, ok := p[namespace+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("pod %s/%s not found", namespace, name)
+	}
+	return pod.DeepCopy(), nil
+}
+
+// NewStaticCache creates a Cache of the pods, which doesn't connect to
+// kubernetes. It's used to inspect pod manifests offline.
+func NewStaticCache(pods ...*corev1.Pod) Cache {
+	sp := staticPods{}
+	for _, pod := range pods {
+		namespace := pod.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		sp[namespace+"/"+pod.Name] = pod
+	}
+	return &kubeCache{pc: sp, logger: log.NewLogger("kube")}
+}
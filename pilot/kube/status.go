@@ -0,0 +1,219 @@
+package kube
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"github.com/caicloud/clientset/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// AnnotationStatus is the pod annotation of collection status, whose
+	// value is a JSON encoded CollectionStatus.
+	AnnotationStatus = "logging.caicloud.io/status"
+	// statusRetryDelay is the delay to retry writing status after failure.
+	statusRetryDelay = 10 * time.Second
+)
+
+// CollectionStatus is the status of log collection of a pod.
+type CollectionStatus struct {
+	// Node is the node where logs are collected.
+	Node string `json:"node"`
+	// Containers are sorted by name.
+	Containers []ContainerStatus `json:"containers"`
+}
+
+// ContainerStatus is the collection status of a container.
+type ContainerStatus struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	// Sources are sorted by name.
+	Sources []SourceStatus `json:"sources"`
+}
+
+// SourceStatus is a log source being collected.
+type SourceStatus struct {
+	Name string `json:"name"`
+	// Path is the path in the container, it's "stdout" for stdout.
+	Path    string `json:"path"`
+	Format  string `json:"format,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	// Since is the time when the source was first collected.
+	Since metav1.Time `json:"since"`
+}
+
+// Container returns the status of the container by name, or nil.
+func (s *CollectionStatus) Container(name string) *ContainerStatus {
+	for i := range s.Containers {
+		if s.Containers[i].Name == name {
+			return &s.Containers[i]
+		}
+	}
+	return nil
+}
+
+// SetContainer adds or replaces status of the container, nil sources remove
+// it. Containers are copied so that status written before is not changed.
+func (s *CollectionStatus) SetContainer(cs ContainerStatus) {
+	containers := make([]ContainerStatus, 0, len(s.Containers)+1)
+	for _, c := range s.Containers {
+		if c.Name != cs.Name {
+			containers = append(containers, c)
+		}
+	}
+	if cs.Sources != nil {
+		sort.Slice(cs.Sources, func(i, j int) bool { return cs.Sources[i].Name < cs.Sources[j].Name })
+		containers = append(containers, cs)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	s.Containers = containers
+}
+
+// collectionStatus decodes status from the pod annotation, it returns nil if
+// the annotation is absent or invalid.
+func collectionStatus(pod *corev1.Pod) *CollectionStatus {
+	anno, ok := pod.Annotations[AnnotationStatus]
+	if !ok {
+		return nil
+	}
+	s := &CollectionStatus{}
+	if err := json.Unmarshal([]byte(anno), s); err != nil {
+		return nil
+	}
+	return s
+}
+
+// StatusWriter writes collection status to pod annotations.
+type StatusWriter interface {
+	// WriteStatus writes the status without blocking. Pending writes of a
+	// pod are replaced by the latest one. Status without containers removes
+	// the annotation.
+	WriteStatus(pod PodRef, status *CollectionStatus)
+}
+
+// NewStatusWriter creates a writer which patches pods in the cluster
+// log-pilot runs in.
+func NewStatusWriter() (StatusWriter, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	w := newStatusWriter(func(pod PodRef, patch []byte) error {
+		_, err := kc.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.MergePatchType, patch)
+		return err
+	})
+	go w.run()
+	return w, nil
+}
+
+// NodeName returns the name of the node log-pilot runs on.
+func NodeName() string {
+	return os.Getenv("NODE_NAME")
+}
+
+type statusWriter struct {
+	patch  func(pod PodRef, patch []byte) error
+	logger log.Logger
+
+	lock    sync.Mutex
+	pending map[PodRef]*CollectionStatus
+	// latest is the last status of pods not written yet, a failed write is
+	// retried only if it's still the latest.
+	latest map[PodRef]*CollectionStatus
+	kick   chan struct{}
+}
+
+func newStatusWriter(patch func(pod PodRef, patch []byte) error) *statusWriter {
+	return &statusWriter{
+		patch:   patch,
+		logger:  log.NewLogger("kube"),
+		pending: make(map[PodRef]*CollectionStatus),
+		latest:  make(map[PodRef]*CollectionStatus),
+		kick:    make(chan struct{}, 1),
+	}
+}
+
+func (w *statusWriter) WriteStatus(pod PodRef, status *CollectionStatus) {
+	w.lock.Lock()
+	w.pending[pod] = status
+	w.latest[pod] = status
+	w.lock.Unlock()
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (w *statusWriter) run() {
+	for range w.kick {
+		w.flush()
+	}
+}
+
+// flush writes all pending status. Failed writes are retried after
+// statusRetryDelay unless replaced by newer status.
+func (w *statusWriter) flush() {
+	w.lock.Lock()
+	pending := w.pending
+	w.pending = make(map[PodRef]*CollectionStatus)
+	w.lock.Unlock()
+
+	for pod, status := range pending {
+		err := w.write(pod, status)
+		if err == nil || errors.IsNotFound(err) || errors.IsConflict(err) {
+			w.lock.Lock()
+			if w.latest[pod] == status {
+				delete(w.latest, pod)
+			}
+			w.lock.Unlock()
+			continue
+		}
+		w.logger.Warnw("Error write collection status", "namespace", pod.Namespace, "pod", pod.Name, "error", err)
+		pod, status := pod, status
+		time.AfterFunc(statusRetryDelay, func() {
+			w.lock.Lock()
+			latest := w.latest[pod] == status
+			w.lock.Unlock()
+			if latest {
+				w.WriteStatus(pod, status)
+			}
+		})
+	}
+}
+
+func (w *statusWriter) write(pod PodRef, status *CollectionStatus) error {
+	var value interface{}
+	if len(status.Containers) > 0 {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	// The uid makes the patch fail if the pod is recreated with the same
+	// name.
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":         pod.UID,
+			"annotations": map[string]interface{}{AnnotationStatus: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return w.patch(pod, patch)
+}
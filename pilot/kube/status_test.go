@@ -0,0 +1,43 @@
+package kube
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatusWriter(t *testing.T) {
+	patches := map[string]map[string]interface{}{}
+	w := newStatusWriter(func(pod PodRef, patch []byte) error {
+		p := map[string]interface{}{}
+		if err := json.Unmarshal(patch, &p); err != nil {
+			t.Fatal(err)
+		}
+		patches[pod.Name] = p["metadata"].(map[string]interface{})
+		return nil
+	})
+
+	status := &CollectionStatus{Node: "node-1"}
+	status.SetContainer(ContainerStatus{Name: "app", ID: "1", Sources: []SourceStatus{{Name: "stdout", Path: "stdout"}}})
+	w.WriteStatus(PodRef{Namespace: "default", Name: "app-0", UID: "uid-0"}, status)
+	w.WriteStatus(PodRef{Namespace: "default", Name: "app-1", UID: "uid-1"}, &CollectionStatus{})
+	w.flush()
+
+	meta := patches["app-0"]
+	if meta["uid"] != "uid-0" {
+		t.Errorf("expect uid in patch, got %v", meta)
+	}
+	anno, _ := meta["annotations"].(map[string]interface{})[AnnotationStatus].(string)
+	written := &CollectionStatus{}
+	if err := json.Unmarshal([]byte(anno), written); err != nil {
+		t.Fatalf("error decode status %q: %v", anno, err)
+	}
+	if written.Node != "node-1" || written.Container("app") == nil {
+		t.Errorf("unexpected status %+v", written)
+	}
+	if v, ok := patches["app-1"]["annotations"].(map[string]interface{})[AnnotationStatus]; !ok || v != nil {
+		t.Errorf("expect annotation removed, got %v", patches["app-1"])
+	}
+	if len(w.latest) != 0 {
+		t.Errorf("expect written status forgotten, got %v", w.latest)
+	}
+}
@@ -14,4 +14,13 @@ type Logger interface {
 	Infof(format string, args ...interface{})
 	Warn(args ...interface{})
 	Warnf(format string, args ...interface{})
+	// Debugw, Infow, Warnw and Errorw log the message with key/value pairs
+	// as fields, e.g. Infow("container started", "pod", "foo").
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+	// With returns a logger which adds the key/value pairs as fields of
+	// every message.
+	With(keysAndValues ...interface{}) Logger
 }
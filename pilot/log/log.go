@@ -28,8 +28,9 @@ func parseLevel(l string) logp.Level {
 	return logp.InfoLevel
 }
 
-// Config initialize logp package
-func Config(level, logPath string, toStderr bool, maxSize, maxBackups uint) {
+// Config initialize logp package. If json is true, logs are written as JSON
+// objects with fields as keys.
+func Config(level, logPath string, toStderr, json bool, maxSize, maxBackups uint) {
 	wd, err := os.Getwd()
 	if err != nil {
 		panic(err)
@@ -41,6 +42,7 @@ func Config(level, logPath string, toStderr bool, maxSize, maxBackups uint) {
 	config := logp.DefaultConfig()
 	config.Level = l
 	config.ToStderr = toStderr
+	config.JSON = json
 	config.Files.Name = "log-pilot.log"
 	config.Files.Path = logPath
 	config.Files.MaxSize = maxSize
@@ -49,7 +51,22 @@ func Config(level, logPath string, toStderr bool, maxSize, maxBackups uint) {
 		panic(err)
 	}
 
-	DefaultLogger = logp.NewLogger("log-pilot")
+	DefaultLogger = NewLogger("log-pilot")
+}
+
+// logger adds With to logp.Logger.
+type logger struct {
+	*logp.Logger
+}
+
+// NewLogger creates a logger of the selector. Loggers created before Config
+// discard all messages.
+func NewLogger(selector string) Logger {
+	return &logger{logp.NewLogger(selector)}
+}
+
+func (l *logger) With(keysAndValues ...interface{}) Logger {
+	return &logger{l.Logger.With(keysAndValues...)}
 }
 
 // Fatal calls the same method of DefaultLogger
@@ -111,3 +128,8 @@ func Warn(args ...interface{}) {
 func Warnf(format string, args ...interface{}) {
 	DefaultLogger.Warnf(format, args...)
 }
+
+// With calls the same method of DefaultLogger
+func With(keysAndValues ...interface{}) Logger {
+	return DefaultLogger.With(keysAndValues...)
+}
@@ -0,0 +1,32 @@
+package log
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONWithFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Config("info", dir, false, true, 1024*1024, 1)
+	NewLogger("test").With("pod", "app-0").Infow("container started", "container", "app")
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "log-pilot.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(data, &line); err != nil {
+		t.Fatalf("expect a JSON line, got %q: %v", data, err)
+	}
+	if line["message"] != "container started" || line["pod"] != "app-0" || line["container"] != "app" {
+		t.Errorf("expect message with fields, got %v", line)
+	}
+}
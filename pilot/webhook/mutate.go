@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/discovery"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annotationInjectVolumes enables volume injection of the pod if set to
+	// "true". It's opt-in since an injected volume hides files of the image
+	// in the directory.
+	annotationInjectVolumes = "logging.caicloud.io/inject-volumes"
+	// injectedVolumePrefix is the name prefix of injected volumes.
+	injectedVolumePrefix = "log-pilot-"
+	// minInjectDepth is the minimum number of path elements of injected
+	// directories, e.g. /var is never replaced by a volume.
+	minInjectDepth = 2
+)
+
+// patchOperation is an operation of JSON patch.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutate injects an emptyDir volume for each log directory not covered by
+// mounts of the container, otherwise logs in it can't be found on host. Only
+// pods annotated with inject-volumes are mutated.
+func (wh *Webhook) mutate(req *AdmissionRequest) *AdmissionResponse {
+	pod, err := decodePod(req)
+	if err != nil {
+		return &AdmissionResponse{
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusBadRequest,
+				Reason:  metav1.StatusReasonBadRequest,
+				Message: fmt.Sprintf("error decode pod: %v", err),
+			},
+		}
+	}
+	if pod == nil || pod.Annotations[annotationInjectVolumes] != "true" {
+		return &AdmissionResponse{Allowed: true}
+	}
+
+	patch, err := wh.volumePatch(pod)
+	if err != nil {
+		// Never block pods, problems are reported by validation.
+		wh.logger.Warnf("error inject volumes of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return &AdmissionResponse{Allowed: true}
+	}
+	if len(patch) == 0 {
+		return &AdmissionResponse{Allowed: true}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return &AdmissionResponse{
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusInternalServerError,
+				Message: err.Error(),
+			},
+		}
+	}
+	wh.logger.Infof("inject %d log volumes to pod %s/%s", len(patch)/2, pod.Namespace, pod.Name)
+	patchType := PatchTypeJSONPatch
+	return &AdmissionResponse{
+		Allowed:   true,
+		Patch:     data,
+		PatchType: &patchType,
+	}
+}
+
+// volumePatch returns operations to add volumes and mounts for log
+// directories of all the containers.
+func (wh *Webhook) volumePatch(pod *corev1.Pod) ([]patchOperation, error) {
+	patch := []patchOperation{}
+	volumes := map[string]bool{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = true
+	}
+	hasVolumes := len(pod.Spec.Volumes) > 0
+
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		sources, err := discovery.DeclaredLogSources(wh.opts.Lint.LogPrefix, pod, c)
+		if err != nil {
+			return nil, err
+		}
+		hasMounts := len(c.VolumeMounts) > 0
+		dirs, _ := uncoveredDirs(sources, c.VolumeMounts)
+		for _, dir := range dirs {
+			name := volumeName(c.Name, dir)
+			if volumes[name] {
+				continue
+			}
+			volumes[name] = true
+
+			volume := corev1.Volume{
+				Name:         name,
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}
+			if hasVolumes {
+				patch = append(patch, patchOperation{Op: "add", Path: "/spec/volumes/-", Value: volume})
+			} else {
+				patch = append(patch, patchOperation{Op: "add", Path: "/spec/volumes", Value: []corev1.Volume{volume}})
+				hasVolumes = true
+			}
+
+			mount := corev1.VolumeMount{Name: name, MountPath: dir}
+			mountsPath := fmt.Sprintf("/spec/containers/%d/volumeMounts", i)
+			if hasMounts {
+				patch = append(patch, patchOperation{Op: "add", Path: mountsPath + "/-", Value: mount})
+			} else {
+				patch = append(patch, patchOperation{Op: "add", Path: mountsPath, Value: []corev1.VolumeMount{mount}})
+				hasMounts = true
+			}
+		}
+	}
+	return patch, nil
+}
+
+// injectProblems returns problems of log directories which are not injected
+// since it's unsafe to replace them with volumes.
+func (wh *Webhook) injectProblems(pod *corev1.Pod) []discovery.Problem {
+	problems := []discovery.Problem{}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		sources, err := discovery.DeclaredLogSources(wh.opts.Lint.LogPrefix, pod, c)
+		if err != nil {
+			// Reported by lint.
+			continue
+		}
+		_, refused := uncoveredDirs(sources, c.VolumeMounts)
+		for _, msg := range refused {
+			problems = append(problems, discovery.Problem{Container: c.Name, Message: msg})
+		}
+	}
+	return problems
+}
+
+// uncoveredDirs returns directories of log sources which are not under any
+// mount. Directories under another one in the result are omitted, since
+// they are covered once the parent is mounted. Directories less than
+// minInjectDepth deep, or parents of glob patterns, are refused, the reasons
+// are returned.
+func uncoveredDirs(sources []string, mounts []corev1.VolumeMount) ([]string, []string) {
+	covered := []string{}
+	for _, m := range mounts {
+		covered = append(covered, filepath.Clean(m.MountPath))
+	}
+
+	dirs := []string{}
+	refused := []string{}
+	for _, source := range sources {
+		if !filepath.IsAbs(source) {
+			continue
+		}
+		dir, globParent := logDir(source)
+		if isUnder(dir, covered) {
+			continue
+		}
+		switch {
+		case depth(dir) < minInjectDepth:
+			refused = append(refused, fmt.Sprintf("volume is not injected for log %s, directory %s is less than %d levels deep", source, dir, minInjectDepth))
+		case globParent:
+			refused = append(refused, fmt.Sprintf("volume is not injected for log %s, directory %s is a parent of glob patterns", source, dir))
+		default:
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) < len(dirs[j])
+	})
+
+	ret := []string{}
+	for _, dir := range dirs {
+		if isUnder(dir, covered) {
+			continue
+		}
+		covered = append(covered, dir)
+		ret = append(ret, dir)
+	}
+	return ret, refused
+}
+
+// logDir returns the directory of the log source, which is the parent of the
+// first path element containing glob patterns. globParent is set if the
+// element is a directory, so the result is not the directory of log files.
+func logDir(source string) (dir string, globParent bool) {
+	source = filepath.Clean(source)
+	if i := strings.IndexAny(source, "*?["); i >= 0 {
+		globParent = strings.Contains(source[i:], "/")
+		source = source[:i]
+		if !strings.HasSuffix(source, "/") {
+			return filepath.Dir(source), globParent
+		}
+		return filepath.Clean(source), globParent
+	}
+	return filepath.Dir(source), false
+}
+
+// depth returns the number of path elements of the absolute path.
+func depth(dir string) int {
+	if dir == "/" {
+		return 0
+	}
+	return strings.Count(dir, "/")
+}
+
+func isUnder(dir string, parents []string) bool {
+	for _, p := range parents {
+		if dir == p || strings.HasPrefix(dir, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeName returns a stable name of volume for the directory, so that
+// reinvocation of the webhook does not inject the volume twice.
+func volumeName(container, dir string) string {
+	return fmt.Sprintf("%s%x", injectedVolumePrefix, sha1.Sum([]byte(container+":"+dir)))[:len(injectedVolumePrefix)+10]
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/discovery"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestUncoveredDirs(t *testing.T) {
+	cases := []struct {
+		sources []string
+		mounts  []string
+		expect  []string
+		refused int
+	}{
+		{[]string{"/var/log/app/a.log", "/var/log/app/b.log"}, nil, []string{"/var/log/app"}, 0},
+		{[]string{"/var/log/app/a.log"}, []string{"/var/log"}, []string{}, 0},
+		{[]string{"/var/log/app/*.log", "/var/log/app-*/a.log"}, nil, []string{"/var/log/app"}, 1},
+		{[]string{"/opt/app/logs/sub/a.log", "/opt/app/logs/a.log"}, nil, []string{"/opt/app/logs"}, 0},
+		{[]string{"logs/a.log", "/a.log", "/var/a.log", "/var/*/a.log"}, nil, []string{}, 3},
+		{[]string{"/var/a.log"}, []string{"/var"}, []string{}, 0},
+	}
+	for _, cas := range cases {
+		mounts := []corev1.VolumeMount{}
+		for _, m := range cas.mounts {
+			mounts = append(mounts, corev1.VolumeMount{Name: "v", MountPath: m})
+		}
+		got, refused := uncoveredDirs(cas.sources, mounts)
+		if !reflect.DeepEqual(got, cas.expect) || len(refused) != cas.refused {
+			t.Errorf("%v: expect %v and %d refused, got %v and %v", cas.sources, cas.expect, cas.refused, got, refused)
+		}
+	}
+}
+
+func TestMutate(t *testing.T) {
+	h := New(Options{Lint: discovery.LintOptions{LogPrefix: "caicloud"}}).Handler()
+	pod := `{
+		"metadata": {"name": "app", "annotations": {"logging.caicloud.io/inject-volumes": "true"}},
+		"spec": {
+			"containers": [{
+				"name": "app",
+				"env": [
+					{"name": "caicloud_log_access", "value": "/var/log/app/access.log"},
+					{"name": "caicloud_log_data", "value": "/data/app.log"}
+				],
+				"volumeMounts": [{"name": "data", "mountPath": "/data"}]
+			}, {
+				"name": "sidecar",
+				"env": [{"name": "caicloud_log_error", "value": "/var/log/sidecar/error.log"}]
+			}],
+			"volumes": [{"name": "data", "emptyDir": {}}]
+		}
+	}`
+	resp := review(t, h, "/mutate", OperationCreate, pod).Response
+	if !resp.Allowed || resp.PatchType == nil || *resp.PatchType != PatchTypeJSONPatch {
+		t.Fatalf("expect allowed with patch, got %+v", resp)
+	}
+	patch := []patchOperation{}
+	if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, op := range patch {
+		paths = append(paths, op.Path)
+	}
+	expect := []string{
+		"/spec/volumes/-",
+		"/spec/containers/0/volumeMounts/-",
+		"/spec/volumes/-",
+		"/spec/containers/1/volumeMounts",
+	}
+	if !reflect.DeepEqual(paths, expect) {
+		t.Errorf("expect patch of %v, got %v", expect, paths)
+	}
+
+	resp = review(t, h, "/mutate", OperationCreate, `{"metadata": {"name": "app"}, "spec": {"containers": [{"name": "app", "env": [{"name": "caicloud_log_a", "value": "/var/log/a.log"}]}]}}`).Response
+	if !resp.Allowed || resp.Patch != nil {
+		t.Errorf("expect no patch if not enabled, got %+v", resp)
+	}
+
+	// Refused directories are reported by validation.
+	h = New(Options{Lint: discovery.LintOptions{LogPrefix: "caicloud"}}).Handler()
+	resp = review(t, h, "/validate", OperationCreate, `{"metadata": {"name": "app", "annotations": {"logging.caicloud.io/inject-volumes": "true"}}, "spec": {"containers": [{"name": "app", "env": [{"name": "caicloud_log_a", "value": "/var/a.log"}]}]}}`).Response
+	if !resp.Allowed || len(resp.Warnings) != 2 {
+		t.Errorf("expect allowed with 2 warnings, got %+v", resp)
+	}
+}
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Types of admission.k8s.io, which are not vendored. Only fields used by the
+// webhook are defined, they are the same in v1beta1 and v1.
+
+// AdmissionReview describes an admission review request and response.
+type AdmissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *AdmissionRequest  `json:"request,omitempty"`
+	Response        *AdmissionResponse `json:"response,omitempty"`
+}
+
+// Operations of admission requests.
+const (
+	OperationCreate = "CREATE"
+	OperationUpdate = "UPDATE"
+)
+
+// AdmissionRequest describes the attributes of an admission request.
+type AdmissionRequest struct {
+	UID       types.UID                   `json:"uid"`
+	Kind      metav1.GroupVersionKind     `json:"kind"`
+	Resource  metav1.GroupVersionResource `json:"resource"`
+	Name      string                      `json:"name,omitempty"`
+	Namespace string                      `json:"namespace,omitempty"`
+	Operation string                      `json:"operation"`
+	Object    json.RawMessage             `json:"object,omitempty"`
+	DryRun    *bool                       `json:"dryRun,omitempty"`
+}
+
+// AdmissionResponse describes an admission response.
+type AdmissionResponse struct {
+	UID     types.UID      `json:"uid"`
+	Allowed bool           `json:"allowed"`
+	Result  *metav1.Status `json:"result,omitempty"`
+	// Patch is applied to the object by mutating webhooks.
+	Patch     []byte  `json:"patch,omitempty"`
+	PatchType *string `json:"patchType,omitempty"`
+	// Warnings are returned to clients since kubernetes 1.19.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PatchTypeJSONPatch is the only patch type supported by admission.
+const PatchTypeJSONPatch = "JSONPatch"
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/discovery"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxRequestBytes limits the size of admission reviews, the API server
+// sends objects no larger than 3MB.
+const maxRequestBytes = 4 * 1024 * 1024
+
+// Options are options of the webhook.
+type Options struct {
+	Lint discovery.LintOptions
+	// Reject pods with fatal logging problems, which means some logs will
+	// never be collected. Pods are allowed with warnings otherwise.
+	Reject bool
+}
+
+// Webhook validates logging configs of pods on creation, with the same
+// parsing code as discovery, and injects volumes for log directories.
+type Webhook struct {
+	opts   Options
+	logger log.Logger
+}
+
+// New creates a webhook.
+func New(opts Options) *Webhook {
+	return &Webhook{
+		opts:   opts,
+		logger: log.NewLogger("webhook"),
+	}
+}
+
+// Handler returns the HTTP handler of the webhook, which serves validating
+// reviews at /validate, and mutating reviews at /mutate.
+func (wh *Webhook) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/validate", wh.serve(wh.validate))
+	mux.Handle("/mutate", wh.serve(wh.mutate))
+	return mux
+}
+
+// admitFunc handles an admission request.
+type admitFunc func(req *AdmissionRequest) *AdmissionResponse
+
+// serve decodes admission reviews and responds in the same version as the
+// request.
+func (wh *Webhook) serve(admit admitFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			http.Error(w, fmt.Sprintf("unsupported content type %q", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := &AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		resp := admit(review.Request)
+		resp.UID = review.Request.UID
+		data, err := json.Marshal(&AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Response: resp,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+// decodePod decodes the pod in request, it returns nil if the request is not
+// a creation of pod.
+func decodePod(req *AdmissionRequest) (*corev1.Pod, error) {
+	if req.Kind.Kind != "Pod" || req.Operation != OperationCreate {
+		return nil, nil
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object, pod); err != nil {
+		return nil, err
+	}
+	// Namespace and name may not be set in the object, e.g. pods created by
+	// controllers have generateName only.
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+	if pod.Name == "" {
+		pod.Name = pod.GenerateName
+	}
+	return pod, nil
+}
+
+// validate lints logging configs of the pod. Only creations are validated,
+// updates of existing pods, e.g. removing finalizers, are never blocked.
+func (wh *Webhook) validate(req *AdmissionRequest) *AdmissionResponse {
+	pod, err := decodePod(req)
+	if err != nil {
+		return &AdmissionResponse{
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusBadRequest,
+				Reason:  metav1.StatusReasonBadRequest,
+				Message: fmt.Sprintf("error decode pod: %v", err),
+			},
+		}
+	}
+	if pod == nil {
+		return &AdmissionResponse{Allowed: true}
+	}
+
+	problems := discovery.LintPod(&wh.opts.Lint, pod)
+	if len(problems) > 0 && pod.Annotations[annotationInjectVolumes] == "true" {
+		// Explain why logs are still not on volumes.
+		problems = append(problems, wh.injectProblems(pod)...)
+	}
+	if len(problems) == 0 {
+		return &AdmissionResponse{Allowed: true}
+	}
+	messages := make([]string, 0, len(problems))
+	fatal := []string{}
+	for _, p := range problems {
+		messages = append(messages, "logging: "+p.String())
+		if p.Fatal {
+			fatal = append(fatal, "logging: "+p.String())
+		}
+	}
+	wh.logger.Infof("pod %s/%s has %d logging problems: %v", pod.Namespace, pod.Name, len(problems), messages)
+
+	if !wh.opts.Reject || len(fatal) == 0 {
+		return &AdmissionResponse{Allowed: true, Warnings: messages}
+	}
+	return &AdmissionResponse{
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: strings.Join(fatal, "; "),
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/discovery"
+)
+
+const podWithProblems = `{
+	"apiVersion": "v1",
+	"kind": "Pod",
+	"metadata": {"generateName": "app-"},
+	"spec": {
+		"containers": [{
+			"name": "app",
+			"env": [{"name": "caicloud_log_access", "value": "/var/log/access.log"}]
+		}]
+	}
+}`
+
+func review(t *testing.T, h http.Handler, path, operation, object string) *AdmissionReview {
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind":       "AdmissionReview",
+		"request": map[string]interface{}{
+			"uid":       "123",
+			"kind":      map[string]string{"group": "", "version": "v1", "kind": "Pod"},
+			"namespace": "demo",
+			"operation": operation,
+			"object":    json.RawMessage(object),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expect status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	ret := &AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret.APIVersion != "admission.k8s.io/v1" || ret.Response == nil || ret.Response.UID != "123" {
+		t.Fatalf("unexpected review %+v", ret)
+	}
+	return ret
+}
+
+func TestValidate(t *testing.T) {
+	lint := discovery.LintOptions{LogPrefix: "caicloud"}
+
+	h := New(Options{Lint: lint}).Handler()
+	resp := review(t, h, "/validate", OperationCreate, podWithProblems).Response
+	if !resp.Allowed || len(resp.Warnings) != 1 {
+		t.Errorf("expect allowed with 1 warning, got %+v", resp)
+	}
+
+	h = New(Options{Lint: lint, Reject: true}).Handler()
+	resp = review(t, h, "/validate", OperationCreate, podWithProblems).Response
+	if resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expect rejected, got %+v", resp)
+	}
+	// Problems with which logs are still collected are not rejected.
+	resp = review(t, h, "/validate", OperationCreate, `{"metadata": {"name": "format"}, "spec": {"containers": [{"name": "app", "env": [
+		{"name": "caicloud_log_stdout", "value": "true"},
+		{"name": "caicloud_log_stdout_format", "value": "xml"}
+	]}]}}`).Response
+	if !resp.Allowed || len(resp.Warnings) != 1 {
+		t.Errorf("expect allowed with 1 warning, got %+v", resp)
+	}
+	resp = review(t, h, "/validate", OperationUpdate, podWithProblems).Response
+	if !resp.Allowed {
+		t.Errorf("expect updates allowed, got %+v", resp)
+	}
+	resp = review(t, h, "/validate", OperationCreate, `{"metadata": {"name": "ok"}, "spec": {"containers": [{"name": "app"}]}}`).Response
+	if !resp.Allowed || len(resp.Warnings) != 0 {
+		t.Errorf("expect allowed without warning, got %+v", resp)
+	}
+
+	// Namespaces ignored by discovery are not validated.
+	lint.BListNS = []string{"demo"}
+	h = New(Options{Lint: lint, Reject: true}).Handler()
+	resp = review(t, h, "/validate", OperationCreate, podWithProblems).Response
+	if !resp.Allowed || len(resp.Warnings) != 0 {
+		t.Errorf("expect allowed without warning, got %+v", resp)
+	}
+}